@@ -0,0 +1,97 @@
+package horm
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"unicode/utf8"
+)
+
+//编解码器,带有json或codec=名称选项的字段保存时编码,读取时解码,可以注册msgpack等其他格式
+type ICodec interface {
+	Name() string                               //编解码器名称,即codec=后面的名称
+	Marshal(v interface{}) ([]byte, error)      //编码字段的值
+	Unmarshal(data []byte, v interface{}) error //把列值解码到字段的指针
+}
+
+var codecMap map[string]ICodec
+
+//编解码器和转换器注册表的锁
+var registryMutex sync.RWMutex
+
+//注册表的版本,每次注册后递增,结构体映射器发现版本变化时清空缓存的列选项
+var registryVersion int
+
+//当前的注册表版本
+func currentRegistryVersion() int {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return registryVersion
+}
+
+//注册编解码器,名称相同时替换已有的编解码器(如使用其他json库替换默认的json编解码器),已经解析过的结构体会重新解析
+func RegisterCodec(c ICodec) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	codecMap[c.Name()] = c
+	registryVersion++
+}
+
+//根据名称获取编解码器
+func GetCodec(name string) (ICodec, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	if c, ok := codecMap[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("codec [%s] not registered", name)
+}
+
+//默认的json编解码器,使用encoding/json
+type jsonCodec struct{}
+
+func (j *jsonCodec) Name() string {
+	return "json"
+}
+
+func (j *jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (j *jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+//使用编解码器把字段的值转换为字面量,nil指针,map和切片转换为NULL,编码结果不是合法的utf8时使用二进制字面量
+func encodeValue(v reflect.Value, c ICodec) (string, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		if v.IsNil() {
+			return "NULL", nil
+		}
+	}
+	b, err := c.Marshal(v.Interface())
+	if err != nil {
+		return "", fmt.Errorf("%s marshal [%s] failed -> %s", c.Name(), v.Type(), err.Error())
+	}
+	if !utf8.Valid(b) {
+		return "X'" + hex.EncodeToString(b) + "'", nil
+	}
+	return quoteString(string(b)), nil
+}
+
+//使用编解码器把列值解码到字段,NULL设置为零值
+func decodeValue(v *reflect.Value, rb sql.RawBytes, null bool, c ICodec) error {
+	v.Set(reflect.Zero(v.Type()))
+	if null {
+		return nil
+	}
+	err := c.Unmarshal(rb, v.Addr().Interface())
+	if err != nil {
+		return fmt.Errorf("%s unmarshal [%s] failed -> %s", c.Name(), v.Type(), err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package horm
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//列的标签选项
+type columnOption struct {
+	converter IConverter //类型转换器,为nil时按字段类型转换
+	codec     ICodec     //编解码器,为nil时按字段类型转换
+	timeType  string     //时间列的类型,date只写入日期,time只写入时间,为空时写入日期和时间
+	precision int        //写入时间时秒的小数位数,为-1时使用管理器的配置
+	scale     int        //浮点数和Decimal的小数位数,为-1时不处理
+}
+
+//解析列名后面的标签选项,没有选项时返回nil,不认识的选项返回错误,t为字段的类型,没有conv选项时使用按类型注册的转换器
+//
+//conv=名称使用注册的转换器,json等价于codec=json,codec=名称使用注册的编解码器,date和time指定时间列的类型,precision=N指定秒的小数位数,
+//scale=N指定浮点数和Decimal的小数位数(如decimal(18,4)对应4)
+func parseColumnOption(tags []string, t reflect.Type) (*columnOption, error) {
+	var option *columnOption
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if tag != "json" && tag != "date" && tag != "time" && !strings.HasPrefix(tag, "codec=") && !strings.HasPrefix(tag, "precision=") &&
+			!strings.HasPrefix(tag, "scale=") && !strings.HasPrefix(tag, "conv=") {
+			return nil, fmt.Errorf("unknown option [%s]", tag)
+		}
+		if option == nil {
+			option = &columnOption{precision: -1, scale: -1}
+		}
+		switch {
+		case strings.HasPrefix(tag, "conv="):
+			converter, err := GetConverter(strings.TrimPrefix(tag, "conv="))
+			if err != nil {
+				return nil, err
+			}
+			option.converter = converter
+		case tag == "json", strings.HasPrefix(tag, "codec="):
+			codec, err := GetCodec(strings.TrimPrefix(tag, "codec="))
+			if err != nil {
+				return nil, err
+			}
+			option.codec = codec
+		case tag == "date", tag == "time":
+			option.timeType = tag
+		case strings.HasPrefix(tag, "precision="):
+			precision, err := strconv.Atoi(strings.TrimPrefix(tag, "precision="))
+			if err != nil || precision < 0 || precision > 9 {
+				return nil, fmt.Errorf("invalid option [%s],precision must be between 0 and 9", tag)
+			}
+			option.precision = precision
+		default:
+			scale, err := strconv.Atoi(strings.TrimPrefix(tag, "scale="))
+			if err != nil || scale < 0 || scale > MAX_DECIMAL_SCALE {
+				return nil, fmt.Errorf("invalid option [%s],scale must be between 0 and %d", tag, MAX_DECIMAL_SCALE)
+			}
+			option.scale = scale
+		}
+	}
+	registryMutex.RLock()
+	converter, ok := typeConverterMap[t]
+	registryMutex.RUnlock()
+	if ok && (option == nil || option.converter == nil) {
+		if option == nil {
+			option = &columnOption{precision: -1, scale: -1}
+		}
+		option.converter = converter
+	}
+	return option, nil
+}
@@ -1,5 +1,12 @@
 package horm
 
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"log"
+	"strings"
+)
+
 var isPrintLog bool = true
 
 func DisableLog() {
@@ -9,3 +16,38 @@ func DisableLog() {
 func EnableLog() {
 	isPrintLog = true
 }
+
+//日志接口,*log.Logger实现了此接口
+type ILogger interface {
+	Printf(format string, v ...interface{})
+}
+
+//默认日志,使用标准库log输出,受DisableLog和EnableLog控制
+type defaultLogger struct{}
+
+func (l *defaultLogger) Printf(format string, v ...interface{}) {
+	if isPrintLog {
+		formatS := color.GreenString(format, v...)
+		log.Printf("[horm]:%s", formatS)
+	}
+}
+
+//不输出的日志,用于低于日志级别的日志
+type nopLogger struct{}
+
+func (l *nopLogger) Printf(format string, v ...interface{}) {}
+
+//解析日志级别,为空时是LOG_DEBUG
+func parseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "", "debug":
+		return LOG_DEBUG, nil
+	case "info":
+		return LOG_INFO, nil
+	case "warn":
+		return LOG_WARN, nil
+	case "off":
+		return LOG_OFF, nil
+	}
+	return LOG_DEBUG, fmt.Errorf("unknown log level [%s]", s)
+}
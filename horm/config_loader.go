@@ -0,0 +1,304 @@
+package horm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//外部配置,可以从yaml,json,toml文件或者环境变量加载
+type Config struct {
+	LogLevel       string        `yaml:"log_level" toml:"log_level" json:"log_level"`                         //日志级别:debug,info,warn,off,默认为debug
+	Dialect        string        `yaml:"dialect" toml:"dialect" json:"dialect"`                               //连接没有指定方言和驱动时使用的方言,默认为mysql
+	SlowThreshold  time.Duration `yaml:"slow_threshold" toml:"slow_threshold" json:"slow_threshold"`          //慢查询阈值,如200ms,为0时不记录慢查询
+	TagName        string        `yaml:"tag_name" toml:"tag_name" json:"tag_name"`                            //列标签名,默认为field
+	TimeZone       string        `yaml:"time_zone" toml:"time_zone" json:"time_zone"`                         //读写时间使用的时区,如Asia/Shanghai,默认为本地时区
+	TimePrecision  int           `yaml:"time_precision" toml:"time_precision" json:"time_precision"`          //写入时间时秒的小数位数
+	ZeroTimeAsNull bool          `yaml:"zero_time_as_null" toml:"zero_time_as_null" json:"zero_time_as_null"` //零值时间保存为NULL
+	Connections    []*ConnConfig `yaml:"connections" toml:"connections" json:"connections"`                   //连接配置
+}
+
+//配置文件中引用环境变量的${VAR}
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+//从文件加载配置,根据扩展名(.yaml,.yml,.json,.toml)选择格式,文件中的${VAR}会被替换为环境变量,其他的$原样保留
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file failed -> %s", err.Error())
+	}
+	data := envPattern.ReplaceAllFunc(b, func(s []byte) []byte {
+		return []byte(os.Getenv(string(s[2 : len(s)-1])))
+	})
+	c := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".json":
+		err = json.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return nil, fmt.Errorf("unknown config file format [%s]", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file [%s] failed -> %s", path, err.Error())
+	}
+	return c, nil
+}
+
+//json中的时间间隔,可以是字符串(如200ms)或者纳秒数
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) != nil {
+		return json.Unmarshal(b, (*time.Duration)(d))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(v)
+	return nil
+}
+
+//json中的时间间隔可以是字符串,durations中的字段按jsonDuration解析,其余字段解析到v(不带UnmarshalJSON的别名类型)
+func parseJSONDuration(b []byte, v interface{}, durations map[string]*time.Duration) error {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for key, raw := range fields {
+		for name, d := range durations {
+			if !strings.EqualFold(key, name) {
+				continue
+			}
+			if err := json.Unmarshal(raw, (*jsonDuration)(d)); err != nil {
+				return fmt.Errorf("parse [%s] failed -> %s", key, err.Error())
+			}
+			delete(fields, key)
+			break
+		}
+	}
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(rest, v)
+}
+
+func (c *Config) UnmarshalJSON(b []byte) error {
+	type config Config
+	return parseJSONDuration(b, (*config)(c), map[string]*time.Duration{"slow_threshold": &c.SlowThreshold})
+}
+
+func (c *ConnConfig) UnmarshalJSON(b []byte) error {
+	type connConfig ConnConfig
+	return parseJSONDuration(b, (*connConfig)(c), map[string]*time.Duration{"health_check_interval": &c.HealthCheckInterval})
+}
+
+func (c *PoolConfig) UnmarshalJSON(b []byte) error {
+	type poolConfig PoolConfig
+	return parseJSONDuration(b, (*poolConfig)(c), map[string]*time.Duration{"conn_max_lifetime": &c.ConnMaxLifetime, "conn_max_idle_time": &c.ConnMaxIdleTime})
+}
+
+//从环境变量加载配置,prefix默认为HORM
+//
+//全局配置为HORM_LOG_LEVEL,HORM_DIALECT,HORM_SLOW_THRESHOLD,HORM_TAG_NAME,HORM_TIME_ZONE,HORM_TIME_PRECISION,HORM_ZERO_TIME_AS_NULL.
+//HORM_CONNECTIONS为逗号分隔的连接名,每个连接的配置为HORM_<连接名>_DSN,HORM_<连接名>_HOST等,
+//没有HORM_CONNECTIONS时使用HORM_DSN,HORM_HOST等配置一个没有名字的连接.
+//连接的配置有DRIVER,DIALECT,DSN,HOST,PORT,SOCKET,USERNAME,PASSWORD,DB_NAME,PARAMS(如charset=utf8&parseTime=true),
+//MAX_OPEN_CONNS,MAX_IDLE_CONNS,CONN_MAX_LIFETIME,CONN_MAX_IDLE_TIME,REPLICAS和FAILOVER(逗号分隔的DSN),HEALTH_CHECK_INTERVAL
+func LoadConfigEnv(prefix string) (*Config, error) {
+	if prefix == "" {
+		prefix = "HORM"
+	}
+	e := &envReader{prefix: prefix}
+	c := &Config{LogLevel: e.str("LOG_LEVEL"), Dialect: e.str("DIALECT"), SlowThreshold: e.duration("SLOW_THRESHOLD"), TagName: e.str("TAG_NAME")}
+	c.TimeZone, c.TimePrecision, c.ZeroTimeAsNull = e.str("TIME_ZONE"), e.int("TIME_PRECISION"), e.bool("ZERO_TIME_AS_NULL")
+	names := e.list("CONNECTIONS")
+	if len(names) == 0 && (e.str("DSN") != "" || e.str("HOST") != "" || e.str("DB_NAME") != "") {
+		names = []string{""}
+	}
+	for _, name := range names {
+		ce := &envReader{prefix: prefix}
+		if name != "" {
+			ce.prefix += "_" + strings.ToUpper(name)
+		}
+		c.Connections = append(c.Connections, ce.connConfig(name))
+		if ce.err != nil {
+			return nil, ce.err
+		}
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return c, nil
+}
+
+//根据配置创建管理器并连接所有的连接,opts中的生成器,日志等无法外部配置的选项可以为nil
+func NewManagerFromConfig(c *Config, opts *Options) (IHormManager, error) {
+	options := Options{}
+	if opts != nil {
+		options = *opts
+	}
+	if c.LogLevel != "" {
+		level, err := parseLogLevel(c.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		options.LogLevel = level
+	}
+	if c.Dialect != "" {
+		options.Dialect = c.Dialect
+	}
+	if c.SlowThreshold > 0 {
+		options.SlowThreshold = c.SlowThreshold
+	}
+	if c.TagName != "" {
+		options.TagName = c.TagName
+	}
+	if c.TimeZone != "" {
+		location, err := time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("load time zone [%s] failed -> %s", c.TimeZone, err.Error())
+		}
+		options.Location = location
+	}
+	if c.TimePrecision > 0 {
+		options.TimePrecision = c.TimePrecision
+	}
+	if c.ZeroTimeAsNull {
+		options.ZeroTimeAsNull = true
+	}
+	m := NewManager(&options)
+	for _, cc := range c.Connections {
+		_, err := m.ConnectConfig(cc)
+		if err != nil {
+			m.CloseAll()
+			return nil, fmt.Errorf("connect [%s] failed -> %s", cc.Name, err.Error())
+		}
+	}
+	return m, nil
+}
+
+//读取带有前缀的环境变量,记录第一个解析错误
+type envReader struct {
+	prefix string
+	err    error
+}
+
+func (e *envReader) str(key string) string {
+	return strings.TrimSpace(os.Getenv(e.prefix + "_" + key))
+}
+
+func (e *envReader) int(key string) int {
+	s := e.str(key)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("parse %s_%s failed -> %s", e.prefix, key, err.Error())
+	}
+	return n
+}
+
+func (e *envReader) bool(key string) bool {
+	s := e.str(key)
+	if s == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("parse %s_%s failed -> %s", e.prefix, key, err.Error())
+	}
+	return b
+}
+
+func (e *envReader) duration(key string) time.Duration {
+	s := e.str(key)
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("parse %s_%s failed -> %s", e.prefix, key, err.Error())
+	}
+	return d
+}
+
+//逗号分隔的列表
+func (e *envReader) list(key string) []string {
+	list := make([]string, 0)
+	for _, s := range strings.Split(e.str(key), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+//url query格式的参数
+func (e *envReader) params(key string) map[string]string {
+	s := e.str(key)
+	if s == "" {
+		return nil
+	}
+	values, err := url.ParseQuery(s)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("parse %s_%s failed -> %s", e.prefix, key, err.Error())
+	}
+	params := make(map[string]string)
+	for k, v := range values {
+		params[k] = v[0]
+	}
+	return params
+}
+
+//读取一个连接的配置
+func (e *envReader) connConfig(name string) *ConnConfig {
+	c := &ConnConfig{
+		Name:                name,
+		Driver:              e.str("DRIVER"),
+		Dialect:             e.str("DIALECT"),
+		DSN:                 e.str("DSN"),
+		Host:                e.str("HOST"),
+		Port:                e.int("PORT"),
+		Socket:              e.str("SOCKET"),
+		UserName:            e.str("USERNAME"),
+		PassWord:            e.str("PASSWORD"),
+		DbName:              e.str("DB_NAME"),
+		Params:              e.params("PARAMS"),
+		HealthCheckInterval: e.duration("HEALTH_CHECK_INTERVAL"),
+	}
+	pool := &PoolConfig{
+		MaxOpenConns:    e.int("MAX_OPEN_CONNS"),
+		MaxIdleConns:    e.int("MAX_IDLE_CONNS"),
+		ConnMaxLifetime: e.duration("CONN_MAX_LIFETIME"),
+		ConnMaxIdleTime: e.duration("CONN_MAX_IDLE_TIME"),
+	}
+	if *pool != (PoolConfig{}) {
+		c.Pool = pool
+	}
+	for _, dsn := range e.list("REPLICAS") {
+		c.Replicas = append(c.Replicas, &ReplicaConfig{DSN: dsn})
+	}
+	for _, dsn := range e.list("FAILOVER") {
+		c.Failover = append(c.Failover, &HostConfig{DSN: dsn})
+	}
+	if c.DSN == "" && c.Host == "" && c.Socket == "" && c.DbName == "" && e.err == nil {
+		e.err = errors.New(e.prefix + "_DSN or " + e.prefix + "_HOST is required")
+	}
+	return c
+}
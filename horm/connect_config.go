@@ -0,0 +1,150 @@
+package horm
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+//连接配置
+type ConnConfig struct {
+	Name     string            `yaml:"name" toml:"name" json:"name"`             //连接名,可以通过连接名创建horm和关闭连接
+	Driver   string            `yaml:"driver" toml:"driver" json:"driver"`       //sql驱动名,为空时使用默认方言的驱动
+	Dialect  string            `yaml:"dialect" toml:"dialect" json:"dialect"`    //方言名,为空时与驱动名相同,用于包装过的驱动(如带有链路追踪的驱动)
+	DSN      string            `yaml:"dsn" toml:"dsn" json:"dsn"`                //完整的数据源名称,设置后忽略下面的连接参数
+	Host     string            `yaml:"host" toml:"host" json:"host"`             //主机
+	Port     int               `yaml:"port" toml:"port" json:"port"`             //端口
+	Socket   string            `yaml:"socket" toml:"socket" json:"socket"`       //unix socket路径,设置后忽略Host和Port
+	UserName string            `yaml:"username" toml:"username" json:"username"` //用户名
+	PassWord string            `yaml:"password" toml:"password" json:"password"` //密码
+	DbName   string            `yaml:"db_name" toml:"db_name" json:"db_name"`    //数据库名
+	Params   map[string]string `yaml:"params" toml:"params" json:"params"`       //驱动参数,如mysql的charset,parseTime,loc,timeout,tls,postgres的sslmode
+	Pool     *PoolConfig       `yaml:"pool" toml:"pool" json:"pool"`             //连接池配置
+	Replicas []*ReplicaConfig  `yaml:"replicas" toml:"replicas" json:"replicas"` //从库,查询默认路由到从库,写操作和事务使用主库
+	Failover []*HostConfig     `yaml:"failover" toml:"failover" json:"failover"` //故障转移的主机,健康检查或Ping发现主库不可用时按顺序切换,执行语句失败不会切换
+	//健康检查间隔,为0时不检查,检查时主库不可用会切换到下一个主机,不可用的从库不再路由查询
+	HealthCheckInterval time.Duration `yaml:"health_check_interval" toml:"health_check_interval" json:"health_check_interval"`
+	//凭证提供者,设置后每次打开新的物理连接时获取账号密码,不能和DSN一起使用
+	Credentials ICredentialsProvider `yaml:"-" toml:"-" json:"-"`
+}
+
+//故障转移的主机配置,使用主库的驱动,方言,账号和连接池配置
+type HostConfig struct {
+	DSN  string `yaml:"dsn" toml:"dsn" json:"dsn"`    //完整的数据源名称,为空时使用主库的连接配置和下面的主机端口
+	Host string `yaml:"host" toml:"host" json:"host"` //主机
+	Port int    `yaml:"port" toml:"port" json:"port"` //端口
+}
+
+//从库配置,使用主库的驱动,方言和连接池配置
+type ReplicaConfig struct {
+	DSN    string `yaml:"dsn" toml:"dsn" json:"dsn"`          //完整的数据源名称,为空时使用主库的连接配置和下面的主机端口
+	Host   string `yaml:"host" toml:"host" json:"host"`       //主机
+	Port   int    `yaml:"port" toml:"port" json:"port"`       //端口
+	Weight int    `yaml:"weight" toml:"weight" json:"weight"` //权重,小于等于0时为1,权重相同时为轮询
+}
+
+//获取从库的连接配置
+func (r *ReplicaConfig) target(primary *ConnConfig) *ConnConfig {
+	return hostTarget(primary, r.DSN, r.Host, r.Port)
+}
+
+//获取故障转移主机的连接配置
+func (h *HostConfig) target(primary *ConnConfig) *ConnConfig {
+	return hostTarget(primary, h.DSN, h.Host, h.Port)
+}
+
+//使用主库的连接配置和另一个主机端口生成连接配置,dsn不为空时直接使用dsn
+func hostTarget(primary *ConnConfig, dsn string, host string, port int) *ConnConfig {
+	c := *primary
+	c.DSN = dsn
+	c.Socket = ""
+	c.Host = host
+	c.Port = port
+	return &c
+}
+
+//连接池配置,字段为零值时使用database/sql的默认设置
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" json:"max_open_conns"`             //最大打开连接数
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" json:"max_idle_conns"`             //最大空闲连接数,小于0时不保留空闲连接
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" json:"conn_max_lifetime"`    //连接最长使用时间
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" json:"conn_max_idle_time"` //连接最长空闲时间
+}
+
+//把连接池配置设置到db上
+func (p *PoolConfig) apply(db *sql.DB) {
+	if p.MaxOpenConns != 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+	if p.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	}
+}
+
+//获取连接使用的方言
+func (c *ConnConfig) getDialect(fallback Dialect) (Dialect, error) {
+	name := c.Dialect
+	if name == "" {
+		name = c.Driver
+	}
+	if name == "" {
+		return fallback, nil
+	}
+	return GetDialect(name)
+}
+
+//获取驱动名
+func (c *ConnConfig) getDriver(d Dialect) string {
+	if c.Driver != "" {
+		return c.Driver
+	}
+	return d.Name()
+}
+
+//获取数据源名称
+func (c *ConnConfig) getDataSourceName(d Dialect) string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+	return d.DataSourceName(c)
+}
+
+//主库和故障转移主机的连接配置,按切换顺序排列
+func (c *ConnConfig) targets() []*ConnConfig {
+	primary := *c
+	targets := []*ConnConfig{&primary}
+	for _, h := range c.Failover {
+		targets = append(targets, h.target(c))
+	}
+	return targets
+}
+
+//获取主机和端口
+func (c *ConnConfig) address() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+//按参数名排序后编码驱动参数
+func (c *ConnConfig) encodeParams() string {
+	if len(c.Params) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(c.Params))
+	for k, _ := range c.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := ""
+	for _, k := range keys {
+		s += "&" + url.QueryEscape(k) + "=" + url.QueryEscape(c.Params[k])
+	}
+	return "?" + s[1:]
+}
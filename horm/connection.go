@@ -0,0 +1,306 @@
+package horm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//数据库连接,包含主库,从库和对应的方言
+type connection struct {
+	name     string
+	db       *sql.DB //主库
+	dialect  Dialect
+	replicas []*replica //从库
+	raw      preparer   //包装的*sql.Conn或*sql.Tx,不为nil时所有语句都在它上面执行
+	config   *ConnConfig
+	targets  []*ConnConfig //主库和故障转移主机的连接配置
+	current  int           //当前主库在targets中的位置
+	logger   ILogger       //健康检查和故障转移的日志
+	stop     chan struct{} //停止健康检查
+	mutex    sync.Mutex
+
+	failoverMutex sync.Mutex //串行执行故障转移,避免并发的检查重复切换
+	closed        bool       //已经关闭,不再故障转移
+}
+
+//故障转移后等待原来的主库上正在执行的语句和事务结束的最长时间,超时后关闭
+var failoverDrainTimeout = time.Minute
+
+//预编译语句的执行者,*sql.DB,*sql.Conn和*sql.Tx都实现了此接口
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+//从库
+type replica struct {
+	db            *sql.DB
+	weight        int  //权重
+	currentWeight int  //平滑加权轮询的当前权重
+	down          bool //健康检查失败时为true,不再路由查询
+}
+
+type forcePrimaryKey struct{}
+
+//强制使用主库查询,用于读取刚写入的数据
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+//是否强制使用主库
+func isForcePrimary(ctx context.Context) bool {
+	force, _ := ctx.Value(forcePrimaryKey{}).(bool)
+	return force
+}
+
+//根据连接配置打开主库和从库,主库不可用时按顺序尝试故障转移的主机
+func openConnection(ctx context.Context, c *ConnConfig, fallback Dialect) (*connection, error) {
+	dialect, err := c.getDialect(fallback)
+	if err != nil {
+		return nil, fmt.Errorf("get dialect failed -> %s", err.Error())
+	}
+	if c.Credentials != nil && c.DSN != "" {
+		return nil, errors.New("credentials provider can not be used with DSN")
+	}
+	config := *c
+	conn := &connection{name: c.Name, dialect: dialect, config: &config, targets: c.targets(), logger: &defaultLogger{}}
+	errs := make([]string, 0, len(conn.targets))
+	for j, target := range conn.targets {
+		db, err := openDB(ctx, target, dialect)
+		if err == nil {
+			conn.db = db
+			conn.current = j
+			break
+		}
+		errs = append(errs, err.Error())
+	}
+	if conn.db == nil {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	for _, r := range c.Replicas {
+		db, err := openDB(ctx, r.target(c), dialect)
+		if err != nil {
+			conn.close()
+			return nil, fmt.Errorf("open replica failed -> %s", err.Error())
+		}
+		weight := r.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		conn.replicas = append(conn.replicas, &replica{db: db, weight: weight})
+	}
+	return conn, nil
+}
+
+//打开数据库并检查是否可以连接,设置了凭证提供者时每次打开物理连接都会重新获取账号密码
+func openDB(ctx context.Context, c *ConnConfig, dialect Dialect) (*sql.DB, error) {
+	dataSourceName := c.getDataSourceName(dialect)
+	var db *sql.DB
+	var err error
+	if c.Credentials != nil && c.DSN == "" {
+		db, err = openCredentialsDB(c, dialect)
+	} else {
+		db, err = sql.Open(c.getDriver(dialect), dataSourceName)
+	}
+	if err != nil {
+		return nil, errors.New("Not connected to the database:" + err.Error())
+	}
+	if c.Pool != nil {
+		c.Pool.apply(db)
+	}
+	if initializer, ok := dialect.(dbInitializer); ok {
+		initializer.initDB(db, dataSourceName)
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, errors.New("Not connected to the database:" + err.Error())
+	}
+	return db, nil
+}
+
+//获取主库,故障转移时主库会被替换
+func (c *connection) primary() *sql.DB {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.db
+}
+
+//获取用于查询的数据库,有从库时按权重平滑轮询选择可用的从库,没有可用的从库时使用主库
+func (c *connection) reader(ctx context.Context) *sql.DB {
+	if len(c.replicas) == 0 || isForcePrimary(ctx) {
+		return c.primary()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	total := 0
+	var selected *replica
+	for _, r := range c.replicas {
+		if r.down {
+			continue
+		}
+		r.currentWeight += r.weight
+		total += r.weight
+		if selected == nil || r.currentWeight > selected.currentWeight {
+			selected = r
+		}
+	}
+	if selected == nil {
+		return c.db
+	}
+	selected.currentWeight -= total
+	return selected.db
+}
+
+//检查主库和从库是否可以连接,主库不可用时切换到下一个可用的主机
+func (c *connection) check(ctx context.Context) error {
+	if c.raw != nil {
+		return nil
+	}
+	errs := make([]string, 0)
+	db := c.primary()
+	err := db.PingContext(ctx)
+	if err != nil {
+		err = c.failover(ctx, db, err)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for j, r := range c.replicas {
+		err := r.db.PingContext(ctx)
+		c.mutex.Lock()
+		r.down = err != nil
+		c.mutex.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("replica [%d] is unreachable -> %s", j, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+//主库failed不可用时按顺序切换到下一个可以连接的主机,切换成功后等待原来的主库上的语句结束再关闭.
+//
+//故障转移只由健康检查和Ping触发,执行语句失败不会触发故障转移
+func (c *connection) failover(ctx context.Context, failed *sql.DB, cause error) error {
+	c.failoverMutex.Lock()
+	defer c.failoverMutex.Unlock()
+	c.mutex.Lock()
+	config, current, db := c.config, c.current, c.db
+	c.mutex.Unlock()
+	if c.closed || db != failed {
+		return nil //连接已经关闭或者其他的检查已经完成了切换
+	}
+	for j := 1; j < len(c.targets); j++ {
+		index := (current + j) % len(c.targets)
+		target := *c.targets[index]
+		target.Pool = config.Pool
+		db, err := openDB(ctx, &target, c.dialect)
+		if err != nil {
+			continue
+		}
+		c.mutex.Lock()
+		if c.db != failed {
+			c.mutex.Unlock()
+			db.Close()
+			return nil
+		}
+		c.db = db
+		c.current = index
+		c.mutex.Unlock()
+		go drainClose(failed, failoverDrainTimeout)
+		c.logger.Printf("Horm-Connection[%s] failover to host [%d].", c.name, index)
+		return nil
+	}
+	return fmt.Errorf("primary is unreachable -> %s", cause.Error())
+}
+
+//等待数据库上正在使用的连接(执行中的语句和事务)归还后关闭,最多等待timeout
+func drainClose(db *sql.DB, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for db.Stats().InUse > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	db.Close()
+}
+
+//定时检查连接,关闭连接时停止
+func (c *connection) startHealthCheck(interval time.Duration) {
+	c.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				err := c.check(ctx)
+				cancel()
+				if err != nil {
+					c.logger.Printf("Horm-Connection[%s] health check failed -> %s", c.name, err.Error())
+				}
+			}
+		}
+	}()
+}
+
+//获取执行语句的数据库,read为true时可以路由到从库
+func (c *connection) preparer(ctx context.Context, read bool) preparer {
+	if c.raw != nil {
+		return c.raw
+	}
+	if read {
+		return c.reader(ctx)
+	}
+	return c.primary()
+}
+
+//在主库或者包装的*sql.Conn上开始事务,包装的*sql.Tx不能再开始事务
+func (c *connection) begin(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	switch raw := c.raw.(type) {
+	case *sql.Conn:
+		return raw.BeginTx(ctx, opts)
+	case *sql.Tx:
+		return nil, errors.New("wrapped transaction can not begin a new transaction")
+	}
+	return c.primary().BeginTx(ctx, opts)
+}
+
+//所有的数据库,主库在第一个
+func (c *connection) dbs() []*sql.DB {
+	dbs := []*sql.DB{c.primary()}
+	for _, r := range c.replicas {
+		dbs = append(dbs, r.db)
+	}
+	return dbs
+}
+
+//停止健康检查,关闭主库和从库,返回第一个错误
+func (c *connection) close() error {
+	c.failoverMutex.Lock()
+	defer c.failoverMutex.Unlock()
+	c.closed = true
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	var closeErr error
+	for _, db := range c.dbs() {
+		if db == nil {
+			continue
+		}
+		err := db.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
@@ -2,5 +2,26 @@ package horm
 
 const (
 	MYSQL      string = "mysql"
+	POSTGRES   string = "postgres"
+	SQLITE     string = "sqlite"
 	COLUMN_TAG string = "field"
 )
+
+//插入模式
+type InsertMode int
+
+const (
+	INSERT         InsertMode = iota //普通插入,记录重复时报错
+	INSERT_IGNORE                    //跳过重复的记录
+	INSERT_REPLACE                   //替换重复的记录,postgres只处理主键冲突
+)
+
+//日志级别
+type LogLevel int
+
+const (
+	LOG_DEBUG LogLevel = iota //输出所有的sql
+	LOG_INFO                  //输出连接的关闭和故障转移
+	LOG_WARN                  //只输出慢查询和健康检查失败
+	LOG_OFF                   //不输出日志
+)
@@ -0,0 +1,169 @@
+package horm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+//类型转换器,在字段的值和数据库的值之间转换,可以按名称注册后通过conv=名称选项使用,也可以按类型注册用于该类型的所有字段
+type IConverter interface {
+	ToDB(value interface{}) (interface{}, error) //把字段的值转换为数据库的值,返回nil时保存为NULL
+	FromDB(src interface{}) (interface{}, error) //把驱动返回的值(NULL为nil)转换为字段的值,返回值需要可以赋值给字段,或者和字段的底层类型相同,数字只在同类之间转换并检查溢出
+}
+
+var converterMap map[string]IConverter
+
+var typeConverterMap map[reflect.Type]IConverter
+
+//按名称注册转换器,名称相同时替换已有的转换器,已经解析过的结构体会重新解析
+func RegisterConverter(name string, c IConverter) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	converterMap[name] = c
+	registryVersion++
+}
+
+//按类型注册转换器,value为该类型的值(如Status(0)),没有conv选项的该类型字段都使用这个转换器,已经解析过的结构体会重新解析
+func RegisterTypeConverter(value interface{}, c IConverter) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	typeConverterMap[reflect.TypeOf(value)] = c
+	registryVersion++
+}
+
+//根据名称获取转换器
+func GetConverter(name string) (IConverter, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	if c, ok := converterMap[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("converter [%s] not registered", name)
+}
+
+//枚举转换器,字段的值和数据库中的字符串一一对应,如EnumConverter{StatusActive: "active", StatusDisabled: "disabled"},没有对应字符串的零值和NULL对应.
+//
+//多个值对应同一个字符串时读取无法确定字段的值,会返回错误
+type EnumConverter map[interface{}]string
+
+func (e EnumConverter) ToDB(value interface{}) (interface{}, error) {
+	if value != nil && !reflect.TypeOf(value).Comparable() {
+		return nil, fmt.Errorf("enum value type [%T] is not comparable", value)
+	}
+	if s, ok := e[value]; ok {
+		return s, nil
+	}
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("enum value [%v] not found", value)
+}
+
+func (e EnumConverter) FromDB(src interface{}) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	s := fmt.Sprint(src)
+	if b, ok := src.([]byte); ok {
+		s = string(b)
+	}
+	var value interface{}
+	found := false
+	for k, v := range e {
+		if v != s {
+			continue
+		}
+		if found {
+			return nil, fmt.Errorf("enum value [%s] is mapped from more than one value", s)
+		}
+		value, found = k, true
+	}
+	if !found {
+		return nil, fmt.Errorf("enum value [%s] not found", s)
+	}
+	return value, nil
+}
+
+//使用转换器把字段的值转换为字面量,转换后的值按类型转换,不再使用编解码器
+func (m *structMapper) convertByConverter(v reflect.Value, option *columnOption) (string, error) {
+	value, err := option.converter.ToDB(v.Interface())
+	if err != nil {
+		return "", fmt.Errorf("convert [%s] to db value failed -> %s", v.Type(), err.Error())
+	}
+	if value == nil {
+		return "NULL", nil
+	}
+	inner := *option
+	inner.converter, inner.codec = nil, nil
+	rv := reflect.ValueOf(value)
+	return m.convertString(rv, rv.Kind(), &inner)
+}
+
+//使用转换器把驱动返回的值设置到字段,转换结果为nil时设置为零值.
+//
+//转换结果需要可以赋值给字段,或者和字段的底层类型相同,整数和浮点数只在同类之间转换并检查溢出
+func scanByConverter(v *reflect.Value, src interface{}, c IConverter) error {
+	value, err := c.FromDB(src)
+	if err != nil {
+		return fmt.Errorf("convert db value to [%s] failed -> %s", v.Type(), err.Error())
+	}
+	if value == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Type().AssignableTo(v.Type()) {
+		v.Set(rv)
+		return nil
+	}
+	err = convertResult(v, rv)
+	if err != nil {
+		return fmt.Errorf("convert db value to [%s] failed -> %s", v.Type(), err.Error())
+	}
+	return nil
+}
+
+//把转换器的返回值转换为字段的类型,只允许底层类型相同或者同类数字之间的转换,溢出时返回错误
+func convertResult(v *reflect.Value, rv reflect.Value) error {
+	t := v.Type()
+	switch {
+	case rv.Kind() == t.Kind() && rv.Type().ConvertibleTo(t) && !isIntKind(t.Kind()) && !isUintKind(t.Kind()) && !isFloatKind(t.Kind()):
+	case isIntKind(rv.Kind()) && isIntKind(t.Kind()):
+		if v.OverflowInt(rv.Int()) {
+			return fmt.Errorf("converter returned [%d] overflows [%s]", rv.Int(), t)
+		}
+	case isUintKind(rv.Kind()) && isUintKind(t.Kind()):
+		if v.OverflowUint(rv.Uint()) {
+			return fmt.Errorf("converter returned [%d] overflows [%s]", rv.Uint(), t)
+		}
+	case isIntKind(rv.Kind()) && isUintKind(t.Kind()):
+		if rv.Int() < 0 || v.OverflowUint(uint64(rv.Int())) {
+			return fmt.Errorf("converter returned [%d] overflows [%s]", rv.Int(), t)
+		}
+	case isUintKind(rv.Kind()) && isIntKind(t.Kind()):
+		if rv.Uint() > math.MaxInt64 || v.OverflowInt(int64(rv.Uint())) {
+			return fmt.Errorf("converter returned [%d] overflows [%s]", rv.Uint(), t)
+		}
+	case isFloatKind(rv.Kind()) && isFloatKind(t.Kind()):
+		if v.OverflowFloat(rv.Float()) {
+			return fmt.Errorf("converter returned [%v] overflows [%s]", rv.Float(), t)
+		}
+	default:
+		return fmt.Errorf("converter returned [%s],can not set to [%s]", rv.Type(), t)
+	}
+	v.Set(rv.Convert(t))
+	return nil
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUintKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
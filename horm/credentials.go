@@ -0,0 +1,128 @@
+package horm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//凭证提供者,每次打开新的物理连接时调用,返回空字符串时使用连接配置中的值
+type ICredentialsProvider interface {
+	Credentials(ctx context.Context) (userName string, passWord string, err error)
+}
+
+//回调函数形式的凭证提供者,可以从密钥管理服务获取账号密码
+type CredentialsFunc func(ctx context.Context) (string, string, error)
+
+func (f CredentialsFunc) Credentials(ctx context.Context) (string, string, error) {
+	return f(ctx)
+}
+
+//从环境变量获取账号密码,变量名为空时使用连接配置中的值
+type EnvCredentials struct {
+	UserNameVar string //用户名的环境变量名
+	PassWordVar string //密码的环境变量名
+}
+
+func (e *EnvCredentials) Credentials(ctx context.Context) (string, string, error) {
+	userName, err := lookupEnv(e.UserNameVar)
+	if err != nil {
+		return "", "", err
+	}
+	passWord, err := lookupEnv(e.PassWordVar)
+	if err != nil {
+		return "", "", err
+	}
+	return userName, passWord, nil
+}
+
+//获取环境变量,变量名不为空但是没有设置时返回错误
+func lookupEnv(key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("environment variable [%s] not found", key)
+	}
+	return value, nil
+}
+
+//从文件获取账号密码(如kubernetes挂载的secret),每次读取文件,文件名为空时使用连接配置中的值
+type FileCredentials struct {
+	UserNameFile string //用户名文件
+	PassWordFile string //密码文件
+}
+
+func (f *FileCredentials) Credentials(ctx context.Context) (string, string, error) {
+	userName, err := readSecretFile(f.UserNameFile)
+	if err != nil {
+		return "", "", err
+	}
+	passWord, err := readSecretFile(f.PassWordFile)
+	if err != nil {
+		return "", "", err
+	}
+	return userName, passWord, nil
+}
+
+//读取文件内容,去掉结尾的换行
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read credentials file failed -> %s", err.Error())
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+//每次打开物理连接时从凭证提供者获取账号密码,生成数据源名称后通过驱动连接
+type credentialsConnector struct {
+	driver  driver.Driver
+	config  *ConnConfig
+	dialect Dialect
+}
+
+func (c *credentialsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	userName, passWord, err := c.config.Credentials.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get credentials failed -> %s", err.Error())
+	}
+	target := *c.config
+	if userName != "" {
+		target.UserName = userName
+	}
+	if passWord != "" {
+		target.PassWord = passWord
+	}
+	dataSourceName := c.dialect.DataSourceName(&target)
+	if dc, ok := c.driver.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(dataSourceName)
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return c.driver.Open(dataSourceName)
+}
+
+func (c *credentialsConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+//使用凭证提供者打开数据库
+func openCredentialsDB(c *ConnConfig, dialect Dialect) (*sql.DB, error) {
+	//database/sql没有按名字获取驱动的方法,通过sql.Open获取驱动,sql.Open不会建立连接
+	db, err := sql.Open(c.getDriver(dialect), dialect.DataSourceName(c))
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	db.Close()
+	return sql.OpenDB(&credentialsConnector{driver: drv, config: c, dialect: dialect}), nil
+}
@@ -0,0 +1,186 @@
+package horm
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//精确的十进制数,用于decimal和numeric列,保存去掉小数点后的整数值和小数位数,不会丢失精度
+//
+//实现了driver.Valuer和sql.Scanner,可以使用*Decimal读写NULL,需要计算时通过Rat转换为big.Rat
+type Decimal struct {
+	value *big.Int //去掉小数点后的整数值,为nil时表示0
+	scale int      //小数位数
+}
+
+//Decimal的指数和小数位数的最大绝对值,避免1e2000000000这样的值占用大量内存
+const MAX_DECIMAL_SCALE int = 1000
+
+//解析十进制数字符串,如123.4500,-0.5,1.5e3,保留字符串中的小数位数,指数和小数位数不能超过MAX_DECIMAL_SCALE
+func NewDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	mantissa, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal [%s]", s)
+		}
+		if e > MAX_DECIMAL_SCALE || e < -MAX_DECIMAL_SCALE {
+			return Decimal{}, fmt.Errorf("decimal [%s] exponent out of range", s)
+		}
+		mantissa, exp = s[:i], e
+	}
+	digits, scale := mantissa, 0
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		digits, scale = mantissa[:i]+mantissa[i+1:], len(mantissa)-i-1
+	}
+	value, ok := new(big.Int).SetString(digits, 10)
+	if !ok || strings.ContainsAny(digits[1:], "+-") {
+		return Decimal{}, fmt.Errorf("invalid decimal [%s]", s)
+	}
+	if scale-exp > MAX_DECIMAL_SCALE {
+		return Decimal{}, fmt.Errorf("decimal [%s] scale out of range", s)
+	}
+	d := Decimal{value: value, scale: scale - exp}
+	if d.scale < 0 {
+		d = d.Round(0) //如1e3,小数位数不能为负数
+	}
+	return d, nil
+}
+
+//把big.Rat转换为scale位小数的十进制数,四舍五入,scale最大为MAX_DECIMAL_SCALE
+func DecimalFromRat(r *big.Rat, scale int) Decimal {
+	if scale < 0 {
+		scale = 0
+	}
+	if scale > MAX_DECIMAL_SCALE {
+		scale = MAX_DECIMAL_SCALE
+	}
+	num := new(big.Int).Mul(r.Num(), pow10(scale))
+	return Decimal{value: quoRound(num, r.Denom()), scale: scale}
+}
+
+//小数位数
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+//转换为scale位小数,四舍五入(远离0),scale最大为MAX_DECIMAL_SCALE
+func (d Decimal) Round(scale int) Decimal {
+	if scale < 0 {
+		scale = 0
+	}
+	if scale > MAX_DECIMAL_SCALE {
+		scale = MAX_DECIMAL_SCALE
+	}
+	value := d.value
+	if value == nil {
+		value = new(big.Int)
+	}
+	if scale >= d.scale {
+		return Decimal{value: new(big.Int).Mul(value, pow10(scale-d.scale)), scale: scale}
+	}
+	return Decimal{value: quoRound(value, pow10(d.scale-scale)), scale: scale}
+}
+
+//转换为big.Rat,用于计算
+func (d Decimal) Rat() *big.Rat {
+	if d.value == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).SetFrac(d.value, pow10(d.scale))
+}
+
+//比较大小,小于,等于,大于o时分别返回-1,0,1
+func (d Decimal) Cmp(o Decimal) int {
+	return d.Rat().Cmp(o.Rat())
+}
+
+//转换为float64,可能丢失精度
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+//转换为字符串,保留所有的小数位数
+func (d Decimal) String() string {
+	if d.value == nil {
+		d.value = new(big.Int)
+	}
+	s := new(big.Int).Abs(d.value).String()
+	if d.scale > 0 {
+		if len(s) <= d.scale {
+			s = strings.Repeat("0", d.scale-len(s)+1) + s
+		}
+		s = s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]
+	}
+	if d.value.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Decimal) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+	case []byte:
+		*d, err = NewDecimal(string(v))
+	case string:
+		*d, err = NewDecimal(v)
+	case int64:
+		*d = Decimal{value: big.NewInt(v)}
+	case float64:
+		*d, err = NewDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		err = fmt.Errorf("can not scan [%T] into decimal", src)
+	}
+	return err
+}
+
+var decimalType = reflect.TypeOf(Decimal{})
+
+//获取Decimal或者非nil的*Decimal字段的值
+func getDecimal(v reflect.Value) (Decimal, bool) {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Type() != decimalType {
+		return Decimal{}, false
+	}
+	return v.Interface().(Decimal), true
+}
+
+//读取的Decimal按列的小数位数四舍五入,如sqlite返回的浮点数没有末尾的0
+func roundDecimal(v reflect.Value, option *columnOption) {
+	if d, ok := getDecimal(v); ok && option != nil && option.scale >= 0 {
+		v.Set(reflect.ValueOf(d.Round(option.scale)))
+	}
+}
+
+//10的n次方
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+//x/y四舍五入(远离0),y为正数
+func quoRound(x *big.Int, y *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(y) >= 0 {
+		if x.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
@@ -0,0 +1,160 @@
+package horm
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+//数据库方言,屏蔽不同数据库之间的sql差异
+type Dialect interface {
+	Name() string                                                                            //方言名称,同时也是sql驱动名
+	DataSourceName(c *ConnConfig) string                                                     //根据连接配置生成数据源名称
+	Placeholder(index int) string                                                            //第index个参数的占位符,index从1开始
+	Quote(identifier string) string                                                          //引用标识符
+	Limit(limit int, offset int) string                                                      //分页子句
+	AutoIncrementValue() string                                                              //插入记录时自增主键的值
+	SupportLastInsertId() bool                                                               //是否支持通过LastInsertId获取自增主键
+	Returning(pkColumnName string) string                                                    //插入记录时返回主键的子句
+	DescribeTableSql(tableName string) string                                                //查询表结构的sql,结果需要包含Field和Type两列
+	Upsert(conflictColumns []string, updateColumns []string) string                          //插入冲突时更新的子句,列名已经引用过
+	InsertMode(mode InsertMode, pkColumns []string, updateColumns []string) (string, string) //插入模式对应的插入语句前缀和后缀,列名已经引用过
+	Literal(value string) string                                                             //把标准sql字面量(字符串'...',二进制X'...')转换为方言的字面量
+}
+
+//打开连接后需要对连接池做额外设置的方言实现此接口
+type dbInitializer interface {
+	initDB(db *sql.DB, dataSourceName string)
+}
+
+var dialectMap map[string]Dialect
+
+//Connect使用的默认方言
+var defaultDialect Dialect = nil
+
+//注册方言
+func RegisterDialect(d Dialect) {
+	dialectMap[d.Name()] = d
+}
+
+//根据名称获取方言
+func GetDialect(name string) (Dialect, error) {
+	if d, ok := dialectMap[name]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("dialect [%s] not registered", name)
+}
+
+//设置New创建的全局管理器连接时使用的默认方言,NewManager创建的管理器使用Options.Dialect
+func SetDialect(name string) error {
+	d, err := GetDialect(name)
+	if err != nil {
+		return err
+	}
+	defaultDialect = d
+	return nil
+}
+
+//引用标识符,带有schema的名称(schema.table)分别引用每一部分,已经引用过的部分不再处理
+func quoteIdentifier(d Dialect, name string) string {
+	parts := splitIdentifier(name)
+	for i, part := range parts {
+		if len(part) >= 2 && strings.ContainsAny(part[:1], "`\"[") && strings.ContainsAny(part[len(part)-1:], "`\"]") {
+			continue
+		}
+		parts[i] = d.Quote(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+//按引号外的.分割带有schema的名称,引号中的.是名称的一部分(如`a.b`)
+func splitIdentifier(name string) []string {
+	parts := make([]string, 0, 2)
+	var quote byte
+	start := 0
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '`' || c == '"':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '.':
+			parts = append(parts, name[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, name[start:])
+}
+
+//把带有schema的名称分割为schema和表名,并去掉引号,没有schema时schema为空
+func splitSchema(name string) (string, string) {
+	parts := splitIdentifier(name)
+	for i, part := range parts {
+		parts[i] = unquoteIdentifier(part)
+	}
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return strings.Join(parts[:len(parts)-1], "."), parts[len(parts)-1]
+}
+
+//去掉标识符的引号,引号中重复的引号还原为一个
+func unquoteIdentifier(part string) string {
+	if len(part) < 2 {
+		return part
+	}
+	switch {
+	case part[0] == '`' && part[len(part)-1] == '`':
+		return strings.ReplaceAll(part[1:len(part)-1], "``", "`")
+	case part[0] == '"' && part[len(part)-1] == '"':
+		return strings.ReplaceAll(part[1:len(part)-1], `""`, `"`)
+	case part[0] == '[' && part[len(part)-1] == ']':
+		return part[1 : len(part)-1]
+	}
+	return part
+}
+
+//ON CONFLICT形式的插入或更新子句,没有需要更新的列时忽略冲突的记录
+func onConflict(conflictColumns []string, updateColumns []string) string {
+	s := " ON CONFLICT (" + strings.Join(conflictColumns, ", ") + ")"
+	if len(updateColumns) == 0 {
+		return s + " DO NOTHING"
+	}
+	set := make([]string, 0, len(updateColumns))
+	for _, column := range updateColumns {
+		set = append(set, column+" = EXCLUDED."+column)
+	}
+	return s + " DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+//把sql中的?占位符替换为方言的占位符,引号中的?不做替换
+func rebind(d Dialect, s string) string {
+	if d.Placeholder(1) == "?" {
+		return s
+	}
+	var b strings.Builder
+	var quote byte
+	index := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			index++
+			b.WriteString(d.Placeholder(index))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
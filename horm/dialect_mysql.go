@@ -0,0 +1,87 @@
+package horm
+
+import (
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+)
+
+type mysqlDialect struct {
+}
+
+func (m *mysqlDialect) Name() string {
+	return MYSQL
+}
+
+//使用驱动的FormatDSN生成数据源名称,账号密码中的/,@等字符不会破坏数据源名称
+func (m *mysqlDialect) DataSourceName(c *ConnConfig) string {
+	config := mysql.NewConfig()
+	config.User, config.Passwd, config.DBName = c.UserName, c.PassWord, c.DbName
+	config.Net, config.Addr = "tcp", c.address()
+	if c.Socket != "" {
+		config.Net, config.Addr = "unix", c.Socket
+	}
+	config.Params = c.Params
+	return config.FormatDSN()
+}
+
+func (m *mysqlDialect) Placeholder(index int) string {
+	return "?"
+}
+
+func (m *mysqlDialect) Quote(identifier string) string {
+	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+}
+
+func (m *mysqlDialect) Limit(limit int, offset int) string {
+	if offset > 0 {
+		return fmt.Sprintf("LIMIT %d, %d", offset, limit)
+	}
+	return fmt.Sprintf("LIMIT %d", limit)
+}
+
+func (m *mysqlDialect) AutoIncrementValue() string {
+	return "DEFAULT"
+}
+
+func (m *mysqlDialect) SupportLastInsertId() bool {
+	return true
+}
+
+func (m *mysqlDialect) Returning(pkColumnName string) string {
+	return ""
+}
+
+//mysql根据主键或任意唯一索引判断冲突,忽略冲突列
+func (m *mysqlDialect) Upsert(conflictColumns []string, updateColumns []string) string {
+	if len(updateColumns) == 0 {
+		return " ON DUPLICATE KEY UPDATE " + conflictColumns[0] + " = " + conflictColumns[0]
+	}
+	set := make([]string, 0, len(updateColumns))
+	for _, column := range updateColumns {
+		set = append(set, column+" = VALUES("+column+")")
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+func (m *mysqlDialect) InsertMode(mode InsertMode, pkColumns []string, updateColumns []string) (string, string) {
+	switch mode {
+	case INSERT_IGNORE:
+		return "INSERT IGNORE INTO", ""
+	case INSERT_REPLACE:
+		return "REPLACE INTO", ""
+	}
+	return "INSERT INTO", ""
+}
+
+//mysql默认把字符串中的反斜杠作为转义符
+func (m *mysqlDialect) Literal(value string) string {
+	if strings.HasPrefix(value, "'") {
+		return strings.ReplaceAll(value, `\`, `\\`)
+	}
+	return value
+}
+
+func (m *mysqlDialect) DescribeTableSql(tableName string) string {
+	return "DESC " + quoteIdentifier(m, tableName)
+}
@@ -0,0 +1,91 @@
+package horm
+
+import (
+	"fmt"
+	_ "github.com/lib/pq"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type postgresDialect struct {
+}
+
+func (p *postgresDialect) Name() string {
+	return POSTGRES
+}
+
+//生成url形式的数据源名称,使用unix socket时把socket目录作为host参数
+func (p *postgresDialect) DataSourceName(c *ConnConfig) string {
+	u := &url.URL{Scheme: "postgres", User: url.UserPassword(c.UserName, c.PassWord), Host: c.address(), Path: "/" + c.DbName}
+	params := url.Values{}
+	for k, v := range c.Params {
+		params.Set(k, v)
+	}
+	if c.Socket != "" {
+		u.Host = ""
+		params.Set("host", c.Socket)
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+func (p *postgresDialect) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+func (p *postgresDialect) Quote(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+func (p *postgresDialect) Limit(limit int, offset int) string {
+	if offset > 0 {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	}
+	return fmt.Sprintf("LIMIT %d", limit)
+}
+
+func (p *postgresDialect) AutoIncrementValue() string {
+	return "DEFAULT"
+}
+
+//lib/pq不支持LastInsertId,通过RETURNING获取自增主键
+func (p *postgresDialect) SupportLastInsertId() bool {
+	return false
+}
+
+func (p *postgresDialect) Returning(pkColumnName string) string {
+	return " RETURNING " + pkColumnName
+}
+
+func (p *postgresDialect) Upsert(conflictColumns []string, updateColumns []string) string {
+	return onConflict(conflictColumns, updateColumns)
+}
+
+//postgres没有REPLACE,根据主键冲突时更新其余列,和mysql,sqlite不同,唯一索引冲突时仍然报错
+func (p *postgresDialect) InsertMode(mode InsertMode, pkColumns []string, updateColumns []string) (string, string) {
+	switch mode {
+	case INSERT_IGNORE:
+		return "INSERT INTO", " ON CONFLICT DO NOTHING"
+	case INSERT_REPLACE:
+		return "INSERT INTO", onConflict(pkColumns, updateColumns)
+	}
+	return "INSERT INTO", ""
+}
+
+//postgres的二进制使用decode函数
+func (p *postgresDialect) Literal(value string) string {
+	if strings.HasPrefix(value, "X'") {
+		return "decode('" + value[2:len(value)-1] + "', 'hex')"
+	}
+	return value
+}
+
+func (p *postgresDialect) DescribeTableSql(tableName string) string {
+	schema, table := splitSchema(tableName)
+	schemaExpr := "current_schema()"
+	if schema != "" {
+		schemaExpr = quoteString(schema)
+	}
+	return fmt.Sprintf(`SELECT column_name AS "Field", data_type AS "Type" FROM information_schema.columns WHERE table_schema = %s AND table_name = %s ORDER BY ordinal_position`, schemaExpr, quoteString(table))
+}
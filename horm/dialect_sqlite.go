@@ -0,0 +1,81 @@
+package horm
+
+import (
+	"database/sql"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"strings"
+)
+
+type sqliteDialect struct {
+}
+
+func (s *sqliteDialect) Name() string {
+	return SQLITE
+}
+
+//sqlite只需要数据库文件路径,:memory:为内存数据库
+func (s *sqliteDialect) DataSourceName(c *ConnConfig) string {
+	return c.DbName + c.encodeParams()
+}
+
+func (s *sqliteDialect) Placeholder(index int) string {
+	return "?"
+}
+
+func (s *sqliteDialect) Quote(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+func (s *sqliteDialect) Limit(limit int, offset int) string {
+	if offset > 0 {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	}
+	return fmt.Sprintf("LIMIT %d", limit)
+}
+
+//向INTEGER PRIMARY KEY插入NULL时自动生成主键
+func (s *sqliteDialect) AutoIncrementValue() string {
+	return "NULL"
+}
+
+func (s *sqliteDialect) SupportLastInsertId() bool {
+	return true
+}
+
+func (s *sqliteDialect) Returning(pkColumnName string) string {
+	return ""
+}
+
+func (s *sqliteDialect) Upsert(conflictColumns []string, updateColumns []string) string {
+	return onConflict(conflictColumns, updateColumns)
+}
+
+func (s *sqliteDialect) InsertMode(mode InsertMode, pkColumns []string, updateColumns []string) (string, string) {
+	switch mode {
+	case INSERT_IGNORE:
+		return "INSERT OR IGNORE INTO", ""
+	case INSERT_REPLACE:
+		return "INSERT OR REPLACE INTO", ""
+	}
+	return "INSERT INTO", ""
+}
+
+func (s *sqliteDialect) Literal(value string) string {
+	return value
+}
+
+func (s *sqliteDialect) DescribeTableSql(tableName string) string {
+	schema, table := splitSchema(tableName)
+	if schema != "" {
+		return fmt.Sprintf("SELECT name AS Field, type AS Type FROM pragma_table_info(%s, %s)", quoteString(table), quoteString(schema))
+	}
+	return fmt.Sprintf("SELECT name AS Field, type AS Type FROM pragma_table_info(%s)", quoteString(table))
+}
+
+//每个连接都会打开一个独立的内存数据库,所以内存数据库只使用一个连接
+func (s *sqliteDialect) initDB(db *sql.DB, dataSourceName string) {
+	if strings.HasPrefix(dataSourceName, ":memory:") {
+		db.SetMaxOpenConns(1)
+	}
+}
@@ -1,51 +1,137 @@
 package horm
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 )
 
 type IHorm interface {
-	List(list interface{}, conditions ...string) error //查询列表
-	FindById(i interface{}) error                      //根据id查找
-	Save(i interface{}) (*Result, error)               //插入单个记录
-	UpdateById(i interface{}) (*Result, error)         //根据id更新
-	DelById(i interface{}) (*Result, error)            //根据id删除
-	Query(string, interface{}) error                   //自定义sql
-	Exec(string) (*Result, error)                      //自定义sql
-	Begin() error                                      //开始事务
-	Commit() error                                     //提交事务
-	RollBack() error                                   //回滚
-	RegistMapping(i interface{}) error                 //注册映射(目前为自动注册)
+	List(list interface{}, conditions ...string) error                                                  //查询列表
+	ListContext(ctx context.Context, list interface{}, conditions ...string) error                      //查询列表
+	FindById(i interface{}) error                                                                       //根据id查找
+	FindByIdContext(ctx context.Context, i interface{}) error                                           //根据id查找
+	Save(i interface{}, modes ...InsertMode) (*Result, error)                                           //插入单个记录,插入模式默认为INSERT
+	SaveContext(ctx context.Context, i interface{}, modes ...InsertMode) (*Result, error)               //插入单个记录,插入模式默认为INSERT
+	SaveAll(list interface{}, modes ...InsertMode) (*Result, error)                                     //批量插入记录,插入模式默认为INSERT
+	SaveAllContext(ctx context.Context, list interface{}, modes ...InsertMode) (*Result, error)         //批量插入记录,插入模式默认为INSERT
+	UpdateById(i interface{}) (*Result, error)                                                          //根据id更新
+	UpdateByIdContext(ctx context.Context, i interface{}) (*Result, error)                              //根据id更新
+	DelById(i interface{}) (*Result, error)                                                             //根据id删除
+	DelByIdContext(ctx context.Context, i interface{}) (*Result, error)                                 //根据id删除
+	Upsert(i interface{}, conflictColumns ...string) (*Result, error)                                   //插入或更新,冲突列默认为主键
+	UpsertContext(ctx context.Context, i interface{}, conflictColumns ...string) (*Result, error)       //插入或更新,冲突列默认为主键
+	UpsertAll(list interface{}, conflictColumns ...string) (*Result, error)                             //批量插入或更新,冲突列默认为主键
+	UpsertAllContext(ctx context.Context, list interface{}, conflictColumns ...string) (*Result, error) //批量插入或更新,冲突列默认为主键
+	Query(s string, i interface{}, args ...interface{}) error                                           //自定义sql,参数使用?占位
+	QueryContext(ctx context.Context, s string, i interface{}, args ...interface{}) error               //自定义sql,参数使用?占位
+	Exec(s string, args ...interface{}) (*Result, error)                                                //自定义sql,参数使用?占位
+	ExecContext(ctx context.Context, s string, args ...interface{}) (*Result, error)                    //自定义sql,参数使用?占位
+	Begin() error                                                                                       //开始事务
+	BeginTx(ctx context.Context, opts *sql.TxOptions) error                                             //开始事务
+	Commit() error                                                                                      //提交事务
+	RollBack() error                                                                                    //回滚
+	AfterCommit(f func()) error                                                                         //注册事务提交成功后的回调
+	AfterRollBack(f func()) error                                                                       //注册事务回滚成功后的回调
+	RegistMapping(i interface{}) error                                                                  //注册映射(目前为自动注册)
+	Dialect() Dialect                                                                                   //获取方言
 }
 
 type defaultHorm struct {
-	db       *sql.DB
-	mappings *resultMap
-	txMap    map[uint64]*sql.Tx
-	mutex    sync.Mutex
+	conn          *connection
+	manager       *HormManager //创建horm的管理器,用于分片时获取其他连接
+	dialect       Dialect
+	generator     ISqlGenerator
+	mapper        *structMapper
+	logger        ILogger       //sql日志
+	slowLogger    ILogger       //慢查询日志
+	slowThreshold time.Duration //慢查询阈值,为0时不记录
+	nested        bool          //分片路由创建的horm,操作计数由原来的horm负责
+	err           error         //创建时的错误(如连接不存在),不为nil时所有操作都返回这个错误
+	mappings      *resultMap
+	txMap         map[uint64]*sql.Tx
+	callbackMap   map[uint64]*txCallbacks
+	mutex         sync.Mutex
+}
+
+//事务回调,只在事务提交或回滚成功后执行
+type txCallbacks struct {
+	afterCommit   []func()
+	afterRollBack []func()
 }
 
 func (d *defaultHorm) List(list interface{}, conditions ...string) error {
+	return d.ListContext(context.Background(), list, conditions...)
+}
+
+func (d *defaultHorm) ListContext(ctx context.Context, list interface{}, conditions ...string) error {
+	err := d.acquire()
+	if err != nil {
+		return err
+	}
+	defer d.release()
 	ele, err := getSliceElem(list)
 	if err != nil {
 		return fmt.Errorf("get slice element failed -> %s", err.Error())
 	}
-	sqlStr, err := sqlGenerator.GenerateListSql(ele, conditions...)
+	if table, ok := ele.(ShardTable); ok {
+		return d.listShards(ctx, table, list, ele, conditions)
+	}
+	sqlStr, err := d.generator.GenerateListSql(ele, conditions...)
 	if err != nil {
 		return fmt.Errorf("Generate sql error:%s", err.Error())
 	}
-	rows, stmt, err := d.query(sqlStr)
+	return d.list(ctx, sqlStr, list, ele)
+}
+
+//依次查询所有分片,结果按分片的顺序追加到同一个切片中,多个分片时不支持排序和分页(每个分片单独排序分页的结果合并后没有意义)
+func (d *defaultHorm) listShards(ctx context.Context, table ShardTable, list interface{}, ele interface{}, conditions []string) error {
+	rule := table.GetShardRule()
+	if rule == nil || rule.Strategy == nil {
+		return errors.New("shard rule can not be empty")
+	}
+	generator, err := d.shardGenerator()
+	if err != nil {
+		return err
+	}
+	shards := rule.Strategy.Shards()
+	if len(shards) == 0 {
+		return errors.New("shard strategy has no shard")
+	}
+	if len(shards) > 1 && hasOrderOrLimit(conditions) {
+		return errors.New("order by and limit are not supported when listing multiple shards")
+	}
+	for _, shard := range shards {
+		h, err := d.shardHorm(shard)
+		if err != nil {
+			return err
+		}
+		sqlStr, err := generator.GenerateShardListSql(ele, shard.Suffix, conditions...)
+		if err != nil {
+			return fmt.Errorf("Generate sql error:%s", err.Error())
+		}
+		err = h.list(ctx, sqlStr, list, ele)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//执行查询并把结果注入到结构体切片
+func (d *defaultHorm) list(ctx context.Context, sqlStr string, list interface{}, ele interface{}) error {
+	rows, stmt, err := d.query(ctx, true, sqlStr)
 	if err != nil {
 		return fmt.Errorf("Query select sql error:%s", err)
 	}
-	err = injectStructList(list, ele, rows)
+	err = injectStructList(d.mapper, list, ele, rows)
 	if err != nil {
+		closeQuery(rows, stmt)
 		return fmt.Errorf("Data inject error:%s", err)
 	}
 	err = rows.Close()
@@ -60,16 +146,33 @@ func (d *defaultHorm) List(list interface{}, conditions ...string) error {
 }
 
 func (d *defaultHorm) FindById(i interface{}) error {
-	sqlStr, err := sqlGenerator.GenerateFindByIdSql(i)
+	return d.FindByIdContext(context.Background(), i)
+}
+
+func (d *defaultHorm) FindByIdContext(ctx context.Context, i interface{}) error {
+	err := d.acquire()
+	if err != nil {
+		return err
+	}
+	defer d.release()
+	h, err := d.route(i)
+	if err != nil {
+		return err
+	}
+	if h != d {
+		return h.FindByIdContext(ctx, i)
+	}
+	sqlStr, err := d.generator.GenerateFindByIdSql(i)
 	if err != nil {
 		return fmt.Errorf("generate sql error:%s", err.Error())
 	}
-	rows, stmt, err := d.query(sqlStr)
+	rows, stmt, err := d.query(ctx, true, sqlStr)
 	if err != nil {
 		return fmt.Errorf("Query select sql error:%s", err)
 	}
-	err = injectOneStruct(i, rows)
+	err = injectOneStruct(d.mapper, i, rows)
 	if err != nil {
+		closeQuery(rows, stmt)
 		return fmt.Errorf("Data inject error:%s", err)
 	}
 	err = rows.Close()
@@ -83,58 +186,236 @@ func (d *defaultHorm) FindById(i interface{}) error {
 	return nil
 }
 
-func (d *defaultHorm) Save(i interface{}) (*Result, error) {
-	sqlStr, err := sqlGenerator.GenerateSaveSql(i)
+func (d *defaultHorm) Save(i interface{}, modes ...InsertMode) (*Result, error) {
+	return d.SaveContext(context.Background(), i, modes...)
+}
+
+func (d *defaultHorm) SaveContext(ctx context.Context, i interface{}, modes ...InsertMode) (*Result, error) {
+	err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	h, err := d.route(i)
+	if err != nil {
+		return nil, err
+	}
+	if h != d {
+		return h.SaveContext(ctx, i, modes...)
+	}
+	sqlStr, err := d.generator.GenerateSaveSql(i, modes...)
 	if err != nil {
 		return nil, fmt.Errorf("generate sql failed:%s", err.Error())
 	}
-	return d.exec(sqlStr)
+	return d.insert(ctx, sqlStr, i, 1, modes)
+}
+
+func (d *defaultHorm) SaveAll(list interface{}, modes ...InsertMode) (*Result, error) {
+	return d.SaveAllContext(context.Background(), list, modes...)
+}
+
+func (d *defaultHorm) SaveAllContext(ctx context.Context, list interface{}, modes ...InsertMode) (*Result, error) {
+	err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	ele, err := getSliceStruct(list)
+	if err != nil {
+		return nil, fmt.Errorf("get slice element failed -> %s", err.Error())
+	}
+	if _, ok := ele.(ShardTable); ok {
+		return d.eachShard(list, func(h *defaultHorm, shardList interface{}) (*Result, error) {
+			return h.saveAll(ctx, shardList, ele, modes)
+		})
+	}
+	return d.saveAll(ctx, list, ele, modes)
+}
+
+//批量插入同一张表的记录
+func (d *defaultHorm) saveAll(ctx context.Context, list interface{}, ele interface{}, modes []InsertMode) (*Result, error) {
+	sqlStr, err := d.generator.GenerateSaveAllSql(list, modes...)
+	if err != nil {
+		return nil, fmt.Errorf("generate sql failed:%s", err.Error())
+	}
+	return d.insert(ctx, sqlStr, ele, reflect.Indirect(reflect.ValueOf(list)).Len(), modes)
+}
+
+//执行插入sql,count为插入的记录数,INSERT_IGNORE模式下统计跳过的记录数
+func (d *defaultHorm) insert(ctx context.Context, sqlStr string, i interface{}, count int, modes []InsertMode) (*Result, error) {
+	returning := false
+	if !d.dialect.SupportLastInsertId() {
+		structInfo, err := d.mapper.getStuctInfo(i)
+		if err != nil {
+			return nil, fmt.Errorf("get struct info failed:%s", err.Error())
+		}
+		returning = structInfo.pkColumnName != ""
+	}
+	var r *Result
+	var err error
+	if returning {
+		r, err = d.insertReturning(ctx, sqlStr)
+	} else {
+		r, err = d.exec(ctx, sqlStr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(modes) > 0 && modes[0] == INSERT_IGNORE {
+		r.Skipped64 = int64(count) - r.RowsAffected64
+		r.Skipped = int(r.Skipped64)
+	}
+	return r, nil
 }
 
 func (d *defaultHorm) UpdateById(i interface{}) (*Result, error) {
-	sqlStr, err := sqlGenerator.GenerateUpdateByIdSql(i)
+	return d.UpdateByIdContext(context.Background(), i)
+}
+
+func (d *defaultHorm) UpdateByIdContext(ctx context.Context, i interface{}) (*Result, error) {
+	err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	h, err := d.route(i)
+	if err != nil {
+		return nil, err
+	}
+	if h != d {
+		return h.UpdateByIdContext(ctx, i)
+	}
+	sqlStr, err := d.generator.GenerateUpdateByIdSql(i)
 	if err != nil {
 		return nil, errors.New("Generate sql failed:" + err.Error())
 	}
-	return d.exec(sqlStr)
+	return d.exec(ctx, sqlStr)
 }
 
 func (d *defaultHorm) DelById(i interface{}) (*Result, error) {
-	sqlStr, err := sqlGenerator.GenerateDelByIdSql(i)
+	return d.DelByIdContext(context.Background(), i)
+}
+
+func (d *defaultHorm) DelByIdContext(ctx context.Context, i interface{}) (*Result, error) {
+	err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	h, err := d.route(i)
+	if err != nil {
+		return nil, err
+	}
+	if h != d {
+		return h.DelByIdContext(ctx, i)
+	}
+	sqlStr, err := d.generator.GenerateDelByIdSql(i)
 	if err != nil {
 		return nil, errors.New("Generate sql failed:" + err.Error())
 	}
-	return d.exec(sqlStr)
+	return d.exec(ctx, sqlStr)
+}
+
+func (d *defaultHorm) Upsert(i interface{}, conflictColumns ...string) (*Result, error) {
+	return d.UpsertContext(context.Background(), i, conflictColumns...)
+}
+
+func (d *defaultHorm) UpsertContext(ctx context.Context, i interface{}, conflictColumns ...string) (*Result, error) {
+	err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	h, err := d.route(i)
+	if err != nil {
+		return nil, err
+	}
+	if h != d {
+		return h.UpsertContext(ctx, i, conflictColumns...)
+	}
+	sqlStr, err := d.generator.GenerateUpsertSql(i, conflictColumns...)
+	if err != nil {
+		return nil, fmt.Errorf("generate sql failed:%s", err.Error())
+	}
+	return d.exec(ctx, sqlStr)
 }
 
-func (d *defaultHorm) Query(s string, i interface{}) error {
+func (d *defaultHorm) UpsertAll(list interface{}, conflictColumns ...string) (*Result, error) {
+	return d.UpsertAllContext(context.Background(), list, conflictColumns...)
+}
+
+func (d *defaultHorm) UpsertAllContext(ctx context.Context, list interface{}, conflictColumns ...string) (*Result, error) {
+	err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	ele, err := getSliceStruct(list)
+	if err != nil {
+		return nil, fmt.Errorf("get slice element failed -> %s", err.Error())
+	}
+	if _, ok := ele.(ShardTable); ok {
+		return d.eachShard(list, func(h *defaultHorm, shardList interface{}) (*Result, error) {
+			return h.upsertAll(ctx, shardList, conflictColumns)
+		})
+	}
+	return d.upsertAll(ctx, list, conflictColumns)
+}
+
+//批量插入或更新同一张表的记录
+func (d *defaultHorm) upsertAll(ctx context.Context, list interface{}, conflictColumns []string) (*Result, error) {
+	sqlStr, err := d.generator.GenerateUpsertAllSql(list, conflictColumns...)
+	if err != nil {
+		return nil, fmt.Errorf("generate sql failed:%s", err.Error())
+	}
+	return d.exec(ctx, sqlStr)
+}
+
+func (d *defaultHorm) Query(s string, i interface{}, args ...interface{}) error {
+	return d.QueryContext(context.Background(), s, i, args...)
+}
+
+func (d *defaultHorm) QueryContext(ctx context.Context, s string, i interface{}, args ...interface{}) error {
+	err := d.acquire()
+	if err != nil {
+		return err
+	}
+	defer d.release()
 	t := reflect.TypeOf(i)
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
-	printLog(s)
-	rows, stmt, err := d.query(s)
+	d.logger.Printf("%s", s)
+	rows, stmt, err := d.query(ctx, true, rebind(d.dialect, s), args...)
 	if err != nil {
 		return fmt.Errorf("Query select sql error:%s", err)
 	}
-	switch t.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.String:
-		err = injectOneField(i, rows)
-	case reflect.Struct:
-		err = injectOneStruct(i, rows)
-	case reflect.Slice:
-		ele, err := getSliceElem(i)
+	switch {
+	case isFieldType(t):
+		err = injectOneField(d.mapper, i, rows)
+	case t.Kind() == reflect.Struct:
+		err = injectOneStruct(d.mapper, i, rows)
+	case t.Kind() == reflect.Slice:
+		var ele interface{}
+		ele, err = getSliceElem(i)
 		if err != nil {
+			closeQuery(rows, stmt)
 			return fmt.Errorf("get slice element failed -> %s", err.Error())
 		}
-		if reflect.TypeOf(ele).Elem().Kind() == reflect.Struct {
-			err = injectStructList(i, ele, rows)
-		} else {
-			err = injectOneFieldList(i, ele, rows)
-		}
-		if err != nil {
-			return fmt.Errorf("Inject one field failed:%s", err.Error())
+		switch et := reflect.TypeOf(ele).Elem(); {
+		case isFieldType(et):
+			err = injectOneFieldList(d.mapper, i, ele, rows)
+		case et.Kind() == reflect.Struct:
+			err = injectStructList(d.mapper, i, ele, rows)
+		default:
+			err = fmt.Errorf("not support type [%s]", et)
 		}
+	default:
+		err = fmt.Errorf("not support type [%s]", t)
+	}
+	if err != nil {
+		closeQuery(rows, stmt)
+		return fmt.Errorf("Data inject error:%s", err)
 	}
 	err = rows.Close()
 	if err != nil {
@@ -147,16 +428,27 @@ func (d *defaultHorm) Query(s string, i interface{}) error {
 	return nil
 }
 
-func (d *defaultHorm) Exec(s string) (*Result, error) {
-	return d.exec(s)
+func (d *defaultHorm) Exec(s string, args ...interface{}) (*Result, error) {
+	return d.ExecContext(context.Background(), s, args...)
+}
+
+func (d *defaultHorm) ExecContext(ctx context.Context, s string, args ...interface{}) (*Result, error) {
+	err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.exec(ctx, rebind(d.dialect, s), args...)
 }
 
-func (d *defaultHorm) exec(sqlStr string) (*Result, error) {
-	stmt, err := d.getStatement(sqlStr)
+func (d *defaultHorm) exec(ctx context.Context, sqlStr string, args ...interface{}) (*Result, error) {
+	start := time.Now()
+	stmt, err := d.getStatement(ctx, false, sqlStr)
 	if err != nil {
 		return nil, fmt.Errorf("Get statement error:%s", err.Error())
 	}
-	result, err := stmt.Exec()
+	result, err := stmt.ExecContext(ctx, args...)
+	d.logSlow(start, sqlStr)
 	if err != nil {
 		return nil, fmt.Errorf("Execute sql error:%s", err.Error())
 	}
@@ -164,9 +456,12 @@ func (d *defaultHorm) exec(sqlStr string) (*Result, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Close statement error:%s", err.Error())
 	}
-	lastInsertId64, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
+	var lastInsertId64 int64
+	if d.dialect.SupportLastInsertId() {
+		lastInsertId64, err = result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
 	}
 	rowsAffected64, err := result.RowsAffected()
 	if err != nil {
@@ -181,53 +476,101 @@ func (d *defaultHorm) exec(sqlStr string) (*Result, error) {
 	return r, nil
 }
 
-func (d *defaultHorm) query(sqlStr string) (*sql.Rows, *sql.Stmt, error) {
-	stmt, err := d.getStatement(sqlStr)
+//执行带有RETURNING子句的插入语句,获取自增主键
+func (d *defaultHorm) insertReturning(ctx context.Context, sqlStr string) (*Result, error) {
+	rows, stmt, err := d.query(ctx, false, sqlStr)
+	if err != nil {
+		return nil, fmt.Errorf("Execute sql error:%s", err.Error())
+	}
+	var lastInsertId64, rowsAffected64 int64
+	for rows.Next() {
+		err = rows.Scan(&lastInsertId64)
+		if err != nil {
+			closeQuery(rows, stmt)
+			return nil, fmt.Errorf("scan returning id failed -> %s", err.Error())
+		}
+		rowsAffected64++
+	}
+	err = rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("close rows failed -> %s", err.Error())
+	}
+	err = stmt.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Close statement error:%s", err.Error())
+	}
+	r := &Result{
+		LastInsertId:   int(lastInsertId64),
+		LastInsertId64: lastInsertId64,
+		RowsAffected:   int(rowsAffected64),
+		RowsAffected64: rowsAffected64,
+	}
+	return r, nil
+}
+
+//执行查询,read为true时可以路由到从库
+func (d *defaultHorm) query(ctx context.Context, read bool, sqlStr string, args ...interface{}) (*sql.Rows, *sql.Stmt, error) {
+	start := time.Now()
+	stmt, err := d.getStatement(ctx, read, sqlStr)
 	if err != nil {
 		return nil, nil, fmt.Errorf("get statement error:%s", err.Error())
 	}
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx, args...)
+	d.logSlow(start, sqlStr)
 	if err != nil {
+		stmt.Close()
 		return nil, nil, fmt.Errorf("execute sql error:%s", err.Error())
 	}
 	return rows, stmt, nil
 }
 
-//向单个字段注入数据
-func injectOneField(i interface{}, rows *sql.Rows) error {
+//注入数据失败时关闭结果集和预编译语句,把连接归还到连接池
+func closeQuery(rows *sql.Rows, stmt *sql.Stmt) {
+	rows.Close()
+	stmt.Close()
+}
+
+//向单个字段注入数据,和结构体的字段一样转换
+func injectOneField(m *structMapper, i interface{}, rows *sql.Rows) error {
 	columns, err := rows.Columns()
 	if err != nil {
-		fmt.Errorf("get columns error:%s", err.Error())
+		return fmt.Errorf("get columns error:%s", err.Error())
 	}
 	if len(columns) != 1 {
-		fmt.Errorf("found [%d] column but 1", len(columns))
+		return fmt.Errorf("found [%d] column but 1", len(columns))
 	}
+	v := reflect.ValueOf(i).Elem()
+	c := &columnValue{}
 	rowNum := 0
 	for rows.Next() {
 		rowNum++
 		if rowNum > 1 {
 			return errors.New("select one but found more")
 		}
-		err = rows.Scan(i)
+		err = rows.Scan(c)
 		if err != nil {
 			return err
 		}
+		err = m.scanValue(&v, c, nil)
+		if err != nil {
+			return fmt.Errorf("set value failed -> %s", err)
+		}
 	}
 	return nil
 }
 
 //向单个结构体注入数据
-func injectOneStruct(i interface{}, rows *sql.Rows) error {
+func injectOneStruct(m *structMapper, i interface{}, rows *sql.Rows) error {
 	columns, err := rows.Columns()
 	if err != nil {
-		fmt.Errorf("get columns error:%s", err.Error())
+		return fmt.Errorf("get columns error:%s", err.Error())
 	}
-	values := make([]sql.RawBytes, len(columns))
+	values := make([]columnValue, len(columns))
 	scans := make([]interface{}, len(columns))
 	for index, _ := range values {
 		scans[index] = &values[index]
 	}
-	sv, err := getStructValue(i)
+	sv, err := m.getStructValue(i)
 	if err != nil {
 		return fmt.Errorf("get struct value failed:%s", err.Error())
 	}
@@ -241,10 +584,10 @@ func injectOneStruct(i interface{}, rows *sql.Rows) error {
 		if err != nil {
 			return err
 		}
-		for k, v := range values {
+		for k := range values {
 			f := sv.fieldValueMap[columns[k]]
 			if f != nil {
-				err = setValue(f, v)
+				err = m.scanValue(f, &values[k], sv.columnOptionMap[columns[k]])
 				if err != nil {
 					return fmt.Errorf("set value failed -> %s", err)
 				}
@@ -255,7 +598,7 @@ func injectOneStruct(i interface{}, rows *sql.Rows) error {
 }
 
 //向单个字段切片注入数据
-func injectOneFieldList(list interface{}, ele interface{}, rows *sql.Rows) error {
+func injectOneFieldList(m *structMapper, list interface{}, ele interface{}, rows *sql.Rows) error {
 	columns, err := rows.Columns()
 	if err != nil {
 		return fmt.Errorf("get columns error:%s", err.Error())
@@ -264,29 +607,35 @@ func injectOneFieldList(list interface{}, ele interface{}, rows *sql.Rows) error
 		return fmt.Errorf("found [%d] columns but one", len(columns))
 	}
 	listValue := reflect.ValueOf(list).Elem()
+	v := reflect.ValueOf(ele).Elem()
+	c := &columnValue{}
 	for rows.Next() {
-		err = rows.Scan(ele)
+		err = rows.Scan(c)
 		if err != nil {
 			return err
 		}
-		listValue.Set(reflect.Append(listValue, reflect.ValueOf(ele).Elem()))
+		err = m.scanValue(&v, c, nil)
+		if err != nil {
+			return fmt.Errorf("set value failed -> %s", err)
+		}
+		listValue.Set(reflect.Append(listValue, v))
 	}
 	return nil
 }
 
 //向结构体切片注入数据
-func injectStructList(list interface{}, ele interface{}, rows *sql.Rows) error {
+func injectStructList(m *structMapper, list interface{}, ele interface{}, rows *sql.Rows) error {
 	columns, err := rows.Columns()
 	if err != nil {
-		fmt.Errorf("get columns error:%s", err.Error())
+		return fmt.Errorf("get columns error:%s", err.Error())
 	}
-	values := make([]sql.RawBytes, len(columns))
+	values := make([]columnValue, len(columns))
 	scans := make([]interface{}, len(columns))
 	for index, _ := range values {
 		scans[index] = &values[index]
 	}
 	listValue := reflect.ValueOf(list).Elem()
-	sv, err := getStructValue(ele)
+	sv, err := m.getStructValue(ele)
 	if err != nil {
 		return fmt.Errorf("get slice [%s] element struct reflect type failed -> %s", listValue.Type().Name(), err.Error())
 	}
@@ -295,10 +644,10 @@ func injectStructList(list interface{}, ele interface{}, rows *sql.Rows) error {
 		if err != nil {
 			return err
 		}
-		for k, v := range values {
+		for k := range values {
 			f := sv.fieldValueMap[columns[k]]
 			if f != nil {
-				err = setValue(f, v)
+				err = m.scanValue(f, &values[k], sv.columnOptionMap[columns[k]])
 				if err != nil {
 					return fmt.Errorf("set value failed -> %s", err)
 				}
@@ -310,38 +659,244 @@ func injectStructList(list interface{}, ele interface{}, rows *sql.Rows) error {
 }
 
 func (d *defaultHorm) Begin() error {
-	printLog("transaction begin↓↓")
+	return d.BeginTx(context.Background(), nil)
+}
+
+func (d *defaultHorm) BeginTx(ctx context.Context, opts *sql.TxOptions) error {
+	d.logger.Printf("transaction begin↓↓")
+	err := d.acquire()
+	if err != nil {
+		return err
+	}
 	d.mutex.Lock()
-	var err error
-	tx, err := d.db.Begin()
+	tx, err := d.conn.begin(ctx, opts)
 	if err != nil {
+		d.mutex.Unlock()
+		d.release()
 		return errors.New("transaction error -> " + err.Error())
 	}
-	d.txMap[getGID()] = tx
+	gid := getGID()
+	d.txMap[gid] = tx
+	d.callbackMap[gid] = &txCallbacks{}
 	return nil
 }
 
 func (d *defaultHorm) Commit() error {
-	printLog("transaction commit↑↑")
-	defer d.mutex.Unlock()
-	return d.txMap[getGID()].Commit()
+	d.logger.Printf("transaction commit↑↑")
+	tx, callbacks, err := d.endTx()
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	d.mutex.Unlock()
+	d.release()
+	if err != nil {
+		return fmt.Errorf("commit failed -> %s", err.Error())
+	}
+	for _, f := range callbacks.afterCommit {
+		f()
+	}
+	return nil
 }
 
 func (d *defaultHorm) RollBack() error {
-	printLog("transaction rollback↑↑")
-	defer d.mutex.Unlock()
-	return d.txMap[getGID()].Rollback()
+	d.logger.Printf("transaction rollback↑↑")
+	tx, callbacks, err := d.endTx()
+	if err != nil {
+		return err
+	}
+	err = tx.Rollback()
+	d.mutex.Unlock()
+	d.release()
+	if err != nil {
+		return fmt.Errorf("rollback failed -> %s", err.Error())
+	}
+	for _, f := range callbacks.afterRollBack {
+		f()
+	}
+	return nil
+}
+
+func (d *defaultHorm) AfterCommit(f func()) error {
+	callbacks, err := d.getCallbacks()
+	if err != nil {
+		return err
+	}
+	callbacks.afterCommit = append(callbacks.afterCommit, f)
+	return nil
+}
+
+func (d *defaultHorm) AfterRollBack(f func()) error {
+	callbacks, err := d.getCallbacks()
+	if err != nil {
+		return err
+	}
+	callbacks.afterRollBack = append(callbacks.afterRollBack, f)
+	return nil
+}
+
+//获取当前goroutine所在事务的回调
+func (d *defaultHorm) getCallbacks() (*txCallbacks, error) {
+	callbacks := d.callbackMap[getGID()]
+	if callbacks == nil {
+		return nil, errors.New("transaction not begun")
+	}
+	return callbacks, nil
+}
+
+//结束当前goroutine的事务,返回事务和注册的回调
+func (d *defaultHorm) endTx() (*sql.Tx, *txCallbacks, error) {
+	gid := getGID()
+	tx := d.txMap[gid]
+	if tx == nil {
+		return nil, nil, errors.New("transaction not begun")
+	}
+	callbacks := d.callbackMap[gid]
+	delete(d.txMap, gid)
+	delete(d.callbackMap, gid)
+	return tx, callbacks, nil
 }
 
 func (d *defaultHorm) RegistMapping(i interface{}) error {
 	return errors.New("Not yet supported")
 }
 
-func (d *defaultHorm) getStatement(s string) (*sql.Stmt, error) {
+func (d *defaultHorm) Dialect() Dialect {
+	return d.dialect
+}
+
+//获取预编译语句,事务中的语句都在主库执行
+func (d *defaultHorm) getStatement(ctx context.Context, read bool, s string) (*sql.Stmt, error) {
 	s = strings.TrimSpace(s)
 	if d.txMap[getGID()] == nil {
-		stmt, err := d.db.Prepare(s)
+		stmt, err := d.conn.preparer(ctx, read).PrepareContext(ctx, s)
 		return stmt, err
 	}
-	return d.txMap[getGID()].Prepare(s)
+	return d.txMap[getGID()].PrepareContext(ctx, s)
+}
+
+//获取结构体所在分片的horm,没有分片或者分片在当前连接时返回自身
+func (d *defaultHorm) route(i interface{}) (*defaultHorm, error) {
+	if _, ok := i.(ShardTable); !ok {
+		return d, nil
+	}
+	if _, err := d.shardGenerator(); err != nil {
+		return nil, err
+	}
+	sv, err := d.mapper.getShardStructValue(i)
+	if err != nil {
+		return nil, fmt.Errorf("get shard failed -> %s", err.Error())
+	}
+	return d.shardHorm(sv.shard)
+}
+
+//获取支持分片的sql生成器,不支持时返回错误,避免生成没有分片后缀的sql
+func (d *defaultHorm) shardGenerator() (IShardSqlGenerator, error) {
+	generator, ok := d.generator.(IShardSqlGenerator)
+	if !ok {
+		return nil, errors.New("sql generator does not implement IShardSqlGenerator")
+	}
+	return generator, nil
+}
+
+//获取分片对应连接的horm,事务中不能跨连接访问分片
+func (d *defaultHorm) shardHorm(shard *Shard) (*defaultHorm, error) {
+	if shard == nil || shard.Did == 0 {
+		return d, nil
+	}
+	if d.manager == nil {
+		return nil, fmt.Errorf("shard connection [%d] not found", shard.Did)
+	}
+	conn, err := d.manager.getConnection(shard.Did)
+	if err != nil {
+		return nil, err
+	}
+	if conn == d.conn {
+		return d, nil
+	}
+	if d.txMap[getGID()] != nil {
+		return nil, fmt.Errorf("shard connection [%d] can not be used in transaction", shard.Did)
+	}
+	h := newDefaultHorm(conn, d.manager)
+	h.nested = true
+	return h, nil
+}
+
+//执行时间超过慢查询阈值时输出sql
+func (d *defaultHorm) logSlow(start time.Time, sqlStr string) {
+	if d.slowThreshold <= 0 {
+		return
+	}
+	cost := time.Since(start)
+	if cost >= d.slowThreshold {
+		d.slowLogger.Printf("slow sql [%s]: %s", cost, sqlStr)
+	}
+}
+
+//开始一个操作,管理器关闭中时拒绝事务外的新操作
+func (d *defaultHorm) acquire() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.manager == nil || d.nested {
+		return nil
+	}
+	return d.manager.acquire(d.txMap[getGID()] != nil)
+}
+
+//结束一个操作
+func (d *defaultHorm) release() {
+	if d.manager != nil && !d.nested {
+		d.manager.release()
+	}
+}
+
+//按分片拆分结构体切片,分别在各自的分片执行,返回汇总的结果
+func (d *defaultHorm) eachShard(list interface{}, f func(h *defaultHorm, shardList interface{}) (*Result, error)) (*Result, error) {
+	v := reflect.Indirect(reflect.ValueOf(list))
+	if v.Len() == 0 {
+		return nil, errors.New("there is no record")
+	}
+	if _, err := d.shardGenerator(); err != nil {
+		return nil, err
+	}
+	keys := make([]Shard, 0)
+	groups := make(map[Shard]reflect.Value)
+	for j := 0; j < v.Len(); j++ {
+		e := v.Index(j)
+		if e.Kind() != reflect.Ptr {
+			e = e.Addr()
+		}
+		sv, err := d.mapper.getShardStructValue(e.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("get element [%d] shard failed -> %s", j, err.Error())
+		}
+		key := Shard{}
+		if sv.shard != nil {
+			key = *sv.shard
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			groups[key] = reflect.MakeSlice(v.Type(), 0, 0)
+		}
+		groups[key] = reflect.Append(groups[key], v.Index(j))
+	}
+	r := &Result{}
+	for _, key := range keys {
+		shard := key
+		h, err := d.shardHorm(&shard)
+		if err != nil {
+			return nil, err
+		}
+		sr, err := f(h, groups[key].Interface())
+		if err != nil {
+			return nil, err
+		}
+		r.LastInsertId, r.LastInsertId64 = sr.LastInsertId, sr.LastInsertId64
+		r.RowsAffected += sr.RowsAffected
+		r.RowsAffected64 += sr.RowsAffected64
+		r.Skipped += sr.Skipped
+		r.Skipped64 += sr.Skipped64
+	}
+	return r, nil
 }
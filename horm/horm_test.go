@@ -1,111 +1,1464 @@
 package horm
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+const createTestTable = `CREATE TABLE tb_test (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	create_time DATETIME,
+	modify_time DATETIME,
+	state       INTEGER,
+	type        INTEGER,
+	description VARCHAR(255)
+)`
+
 func TestHorm(t *testing.T) {
-	//创建一个HormManager
+	//创建一个HormManager,使用sqlite内存数据库
 	hormManager := New()
-	did, err := hormManager.Connect("127.0.0.1", 3306, "root", "root", "horm")
-	dealError(err)
-	t.Logf("did=%d", did)
-
-	//创建一个horm
-	horm := hormManager.Create(did)
+	horm := newTestDB(t, hormManager)
 
 	//开始一个事务
-	err = horm.Begin()
-	dealError(err)
+	err := horm.Begin()
+	dealError(t, err)
 
 	//创建一个测试struct
 	th := newTestHorm()
 
 	//保存新建的struct
 	res, err := horm.Save(th)
-	dealError(err)
+	dealError(t, err)
+	if res.LastInsertId == 0 || res.RowsAffected != 1 {
+		t.Fatalf("save result=%+v", res)
+	}
 
-	//删除新建的struct
+	//查询新建的struct
 	th.Id = res.LastInsertId
-	rows, err := horm.DelById(th)
-	dealError(err)
-	t.Logf("rows=%d", rows)
-
-	//查询id = 9的struct
-	th2 := &testHorm{Id: 9}
+	th2 := &testHorm{Id: th.Id}
 	err = horm.FindById(th2)
-	dealError(err)
-	t.Logf("%+v", th2)
+	dealError(t, err)
+	if th2.Description != th.Description || !th2.CreateTime.Equal(th.CreateTime) {
+		t.Fatalf("find by id got %+v, want %+v", th2, th)
+	}
 
 	//更新struct
 	th2.Description = "更新 horm"
-	rows, err = horm.UpdateById(th2)
-	dealError(err)
-	t.Logf("更新了[%d]条记录", rows)
+	rows, err := horm.UpdateById(th2)
+	dealError(t, err)
+	t.Logf("更新了[%d]条记录", rows.RowsAffected)
 
 	//自定义更新操作
-	result, err := horm.Exec("update tb_test set state = 1 where state = 9")
-	dealError(err)
-	t.Logf("更新了[%d]条记录", result.RowsAffected)
+	result, err := horm.Exec("update tb_test set state = ? where id = ?", 9, th.Id)
+	dealError(t, err)
+	if result.RowsAffected != 1 {
+		t.Fatalf("exec rows affected=%d", result.RowsAffected)
+	}
 
 	//自定义查询单条记录操作
 	single := &testHorm{}
-	err = horm.Query("select * from tb_test where id = 9", single)
-	dealError(err)
-	t.Logf("%+v", single)
+	err = horm.Query("select * from tb_test where id = ?", single, th.Id)
+	dealError(t, err)
+	if single.Description != "更新 horm" || single.State != 9 {
+		t.Fatalf("query single got %+v", single)
+	}
 
-	//自定义查询单条记录操作
+	//自定义查询多条记录操作
+	_, err = horm.Save(newTestHorm())
+	dealError(t, err)
 	list := new([]testHorm)
 	err = horm.Query("select * from tb_test", list)
-	dealError(err)
-	for _, v := range *list {
-		t.Logf("---%+v", v)
+	dealError(t, err)
+	if len(*list) != 2 {
+		t.Fatalf("query list got %d records", len(*list))
 	}
 
 	//自定义查询单个字段操作
 	tp := 0
-	err = horm.Query("select type from tb_test where id = 9", &tp)
-	dealError(err)
-	t.Logf("type=%d", tp)
+	err = horm.Query("select type from tb_test where id = ?", &tp, th.Id)
+	dealError(t, err)
 	des := ""
-	err = horm.Query("select description from tb_test where id = 9", &des)
-	dealError(err)
-	t.Logf("description=%s", des)
+	err = horm.Query("select description from tb_test where id = ?", &des, th.Id)
+	dealError(t, err)
+	if des != "更新 horm" {
+		t.Fatalf("description=%s", des)
+	}
 
 	//自定义查询单个列表操作
 	ids := new([]*int)
 	err = horm.Query("select id from tb_test", ids)
-	dealError(err)
-	for _, v := range *ids {
-		t.Logf("id=%d", *v)
+	dealError(t, err)
+	if len(*ids) != 2 || *(*ids)[0] != th.Id {
+		t.Fatalf("ids=%v", *ids)
 	}
 
-	//查询id = 9的struct
-	th2 = &testHorm{Id: 9}
-	err = horm.FindById(th2)
-	dealError(err)
-	t.Logf("%+v", th2)
-
+	//条件查询
 	ths := new([]testHorm)
-	err = horm.List(ths)
-	dealError(err)
-	for _, v := range *ths {
-		t.Logf("%+v", v)
+	err = horm.List(ths, "state = 9", "id desc", "limit 10")
+	dealError(t, err)
+	if len(*ths) != 1 || (*ths)[0].Id != th.Id {
+		t.Fatalf("list got %+v", *ths)
+	}
+
+	//删除新建的struct
+	rows, err = horm.DelById(th)
+	dealError(t, err)
+	if rows.RowsAffected != 1 {
+		t.Fatalf("delete rows affected=%d", rows.RowsAffected)
 	}
 
 	//提交事务
 	err = horm.Commit()
-	dealError(err)
+	dealError(t, err)
 
 	//关闭所有连接
 	err = hormManager.CloseAll()
-	dealError(err)
+	dealError(t, err)
+}
+
+func TestTransactionCallback(t *testing.T) {
+	horm := newTestDB(t, New())
+
+	committed, rolledBack := 0, 0
+	err := horm.AfterCommit(func() {})
+	if err == nil {
+		t.Fatal("register callback without transaction should fail")
+	}
+
+	//回滚时只执行回滚回调
+	dealError(t, horm.Begin())
+	dealError(t, horm.AfterCommit(func() { committed++ }))
+	dealError(t, horm.AfterRollBack(func() { rolledBack++ }))
+	_, err = horm.Save(newTestHorm())
+	dealError(t, err)
+	dealError(t, horm.RollBack())
+	if committed != 0 || rolledBack != 1 {
+		t.Fatalf("committed=%d rolledBack=%d", committed, rolledBack)
+	}
+
+	//提交时只执行提交回调,回滚的数据不可见
+	dealError(t, horm.Begin())
+	dealError(t, horm.AfterCommit(func() { committed++ }))
+	dealError(t, horm.AfterRollBack(func() { rolledBack++ }))
+	_, err = horm.Save(newTestHorm())
+	dealError(t, err)
+	dealError(t, horm.Commit())
+	if committed != 1 || rolledBack != 1 {
+		t.Fatalf("committed=%d rolledBack=%d", committed, rolledBack)
+	}
+	count := 0
+	dealError(t, horm.Query("select count(*) from tb_test", &count))
+	if count != 1 {
+		t.Fatalf("count=%d", count)
+	}
+}
+
+func TestTransactionCallbackOrder(t *testing.T) {
+	horm := newTestDB(t, New())
+
+	//回调按注册顺序执行,事务结束后回调被清除,不会在下一个事务中再次执行
+	calls := make([]string, 0)
+	dealError(t, horm.Begin())
+	dealError(t, horm.AfterCommit(func() { calls = append(calls, "commit1") }))
+	dealError(t, horm.AfterCommit(func() { calls = append(calls, "commit2") }))
+	dealError(t, horm.Commit())
+	dealError(t, horm.Begin())
+	dealError(t, horm.AfterRollBack(func() { calls = append(calls, "rollback") }))
+	dealError(t, horm.RollBack())
+	if strings.Join(calls, ",") != "commit1,commit2,rollback" {
+		t.Fatalf("calls got %v", calls)
+	}
+
+	//事务结束后不能注册回调,也不能再次提交
+	if err := horm.AfterRollBack(func() {}); err == nil {
+		t.Fatal("register callback after rollback should fail")
+	}
+	if err := horm.Commit(); err == nil {
+		t.Fatal("commit without transaction should fail")
+	}
+}
+
+func TestPostgresDialect(t *testing.T) {
+	postgres, err := GetDialect(POSTGRES)
+	dealError(t, err)
+
+	//?替换为$n,引号中的?不替换
+	if s := rebind(postgres, "select * from tb_test where id = ? and description = '?' and \"type?\" = ?"); s != "select * from tb_test where id = $1 and description = '?' and \"type?\" = $2" {
+		t.Fatalf("rebind got %s", s)
+	}
+	sqlite, err := GetDialect(SQLITE)
+	dealError(t, err)
+	if s := rebind(sqlite, "select ?"); s != "select ?" {
+		t.Fatalf("sqlite rebind got %s", s)
+	}
+
+	//表名中的单引号被转义
+	if s := postgres.DescribeTableSql("o'neil.tb_test"); !strings.Contains(s, "table_schema = 'o''neil' AND table_name = 'tb_test'") {
+		t.Fatalf("postgres describe got %s", s)
+	}
+	//引号中的.是名称的一部分
+	if s := postgres.DescribeTableSql(`"sales"."tb.test"`); !strings.Contains(s, "table_schema = 'sales' AND table_name = 'tb.test'") {
+		t.Fatalf("postgres describe quoted got %s", s)
+	}
+	if s := postgres.DescribeTableSql(`"tb.test"`); !strings.Contains(s, "table_schema = current_schema() AND table_name = 'tb.test'") {
+		t.Fatalf("postgres describe quoted got %s", s)
+	}
+	if s := sqlite.DescribeTableSql("tb'test"); s != "SELECT name AS Field, type AS Type FROM pragma_table_info('tb''test')" {
+		t.Fatalf("sqlite describe got %s", s)
+	}
+
+	//生成的sql使用双引号引用标识符,自增主键使用RETURNING,二进制使用decode
+	generator := newDefaultSqlGenerator(postgres, defaultMapper, &nopLogger{})
+	s, err := generator.GenerateFindByIdSql(&testHorm{Id: 1})
+	dealError(t, err)
+	if !strings.HasPrefix(s, "SELECT ") || !strings.HasSuffix(s, ` FROM "tb_test" WHERE "id" = 1`) {
+		t.Fatalf("find by id sql got %s", s)
+	}
+	s, err = generator.GenerateSaveSql(&testScalar{Data: []byte{0xab}, Note: `a'\b`})
+	dealError(t, err)
+	if !strings.Contains(s, "decode('ab', 'hex')") || !strings.Contains(s, `'a''\b'`) || !strings.HasSuffix(s, ` RETURNING "id"`) {
+		t.Fatalf("save sql got %s", s)
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	mysql, err := GetDialect(MYSQL)
+	dealError(t, err)
+	if s := quoteIdentifier(mysql, "horm.tb_test"); s != "`horm`.`tb_test`" {
+		t.Fatalf("quote schema-qualified name got %s", s)
+	}
+	postgres, err := GetDialect(POSTGRES)
+	dealError(t, err)
+	if s := quoteIdentifier(postgres, `public."tb-test"`); s != `"public"."tb-test"` {
+		t.Fatalf("quote quoted name got %s", s)
+	}
+
+	//引号中的.不分割
+	for name, want := range map[string]string{"`a.b`": "`a.b`", "horm.`a.b`": "`horm`.`a.b`", "`a``.b`.c": "`a``.b`.`c`"} {
+		if s := quoteIdentifier(mysql, name); s != want {
+			t.Fatalf("quote %s got %s, want %s", name, s, want)
+		}
+	}
+
+	//表名和列名为关键字或者带有特殊字符
+	horm := newTestDB(t, New())
+	_, err = horm.Exec(`CREATE TABLE "tb-keyword" (id INTEGER PRIMARY KEY AUTOINCREMENT, "order" INTEGER, "desc" VARCHAR(32))`)
+	dealError(t, err)
+	res, err := horm.Save(&testKeyword{Order: 1, Desc: "keyword"})
+	dealError(t, err)
+	tk := &testKeyword{Id: res.LastInsertId}
+	dealError(t, horm.FindById(tk))
+	if tk.Order != 1 || tk.Desc != "keyword" {
+		t.Fatalf("find by id got %+v", tk)
+	}
+	tk.Order = 2
+	_, err = horm.UpdateById(tk)
+	dealError(t, err)
+	list := new([]testKeyword)
+	dealError(t, horm.List(list))
+	if len(*list) != 1 || (*list)[0].Order != 2 {
+		t.Fatalf("list got %+v", *list)
+	}
+	_, err = horm.DelById(tk)
+	dealError(t, err)
+}
+
+func TestUpsert(t *testing.T) {
+	horm := newTestDB(t, New())
+	_, err := horm.Exec("CREATE UNIQUE INDEX uk_description ON tb_test(description)")
+	dealError(t, err)
+
+	//主键冲突时更新
+	th := newTestHorm()
+	res, err := horm.Upsert(th)
+	dealError(t, err)
+	th.Id = res.LastInsertId
+	th.State = 1
+	_, err = horm.Upsert(th)
+	dealError(t, err)
+
+	//批量插入或更新,根据唯一索引判断冲突
+	th2 := newTestHorm()
+	th2.State = 2
+	th3 := newTestHorm()
+	th3.Description = "批量插入"
+	_, err = horm.UpsertAll([]*testHorm{th2, th3}, "description")
+	dealError(t, err)
+
+	list := new([]testHorm)
+	dealError(t, horm.List(list, "id asc"))
+	if len(*list) != 2 || (*list)[0].Id != th.Id || (*list)[0].State != 2 || (*list)[1].Description != "批量插入" {
+		t.Fatalf("list got %+v", *list)
+	}
+}
+
+func TestSaveMode(t *testing.T) {
+	horm := newTestDB(t, New())
+	_, err := horm.Exec("CREATE UNIQUE INDEX uk_description ON tb_test(description)")
+	dealError(t, err)
+
+	th := newTestHorm()
+	res, err := horm.Save(th)
+	dealError(t, err)
+	th.Id = res.LastInsertId
+
+	//重复的记录报错
+	_, err = horm.Save(newTestHorm())
+	if err == nil {
+		t.Fatal("save duplicate record should fail")
+	}
+
+	//跳过重复的记录
+	th2 := newTestHorm()
+	th2.Description = "批量插入"
+	res, err = horm.SaveAll([]*testHorm{newTestHorm(), th2}, INSERT_IGNORE)
+	dealError(t, err)
+	if res.RowsAffected != 1 || res.Skipped != 1 {
+		t.Fatalf("save ignore result=%+v", res)
+	}
+
+	//替换重复的记录
+	th.State = 3
+	res, err = horm.Save(th, INSERT_REPLACE)
+	dealError(t, err)
+	th3 := &testHorm{Id: th.Id}
+	dealError(t, horm.FindById(th3))
+	if th3.State != 3 {
+		t.Fatalf("find by id got %+v", th3)
+	}
+
+	//不为零的自增主键按指定的值插入
+	th4 := newTestHorm()
+	th4.Id, th4.Description = 100, "指定主键"
+	res, err = horm.Save(th4)
+	dealError(t, err)
+	if res.LastInsertId != 100 {
+		t.Fatalf("save with id got %d", res.LastInsertId)
+	}
+
+	//postgres根据主键冲突替换
+	postgres, err := GetDialect(POSTGRES)
+	dealError(t, err)
+	s, err := newDefaultSqlGenerator(postgres, defaultMapper, &nopLogger{}).GenerateSaveSql(th, INSERT_REPLACE)
+	dealError(t, err)
+	if !strings.Contains(s, `ON CONFLICT ("id") DO UPDATE SET`) {
+		t.Fatalf("postgres replace sql got %s", s)
+	}
+	_, err = newDefaultSqlGenerator(postgres, defaultMapper, &nopLogger{}).GenerateSaveSql(&testNoPk{Name: "a"}, INSERT_REPLACE)
+	if err == nil || !strings.Contains(err.Error(), "has no primary key") {
+		t.Fatalf("postgres replace without primary key got %v", err)
+	}
+}
+
+func TestConnectConfig(t *testing.T) {
+	mysql, err := GetDialect(MYSQL)
+	dealError(t, err)
+	c := &ConnConfig{Socket: "/tmp/mysql.sock", UserName: "root", PassWord: "root", DbName: "horm", Params: map[string]string{"parseTime": "true", "loc": "Asia/Shanghai"}}
+	if dsn := mysql.DataSourceName(c); dsn != "root:root@unix(/tmp/mysql.sock)/horm?loc=Asia%2FShanghai&parseTime=true" {
+		t.Fatalf("mysql dsn=%s", dsn)
+	}
+
+	//密码中的特殊字符
+	c = &ConnConfig{Host: "127.0.0.1", Port: 3306, UserName: "root", PassWord: "p/a@ss:w?", DbName: "horm"}
+	parsed, err := mysqldriver.ParseDSN(mysql.DataSourceName(c))
+	dealError(t, err)
+	if parsed.Passwd != c.PassWord || parsed.DBName != "horm" || parsed.Addr != "127.0.0.1:3306" {
+		t.Fatalf("mysql dsn parsed as %+v", parsed)
+	}
+
+	//通过驱动参数打开sqlite外键约束
+	hormManager := New()
+	did, err := hormManager.ConnectConfig(&ConnConfig{Driver: SQLITE, DbName: ":memory:", Params: map[string]string{"_pragma": "foreign_keys(1)"}})
+	dealError(t, err)
+	foreignKeys := 0
+	dealError(t, hormManager.Create(did).Query("PRAGMA foreign_keys", &foreignKeys))
+	if foreignKeys != 1 {
+		t.Fatalf("foreign_keys=%d", foreignKeys)
+	}
+
+	_, err = hormManager.ConnectDSN("unknown", "")
+	if err == nil {
+		t.Fatal("connect with unknown driver should fail")
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	hormManager := New()
+	testCtxOnce.Do(func() { sql.Register("horm_ctx_test", testCtx) })
+	driver := testCtx
+	driver.deadline = false
+
+	//连接时的deadline传递到驱动
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	_, err := hormManager.ConnectConfigContext(ctx, &ConnConfig{Driver: "horm_ctx_test", Dialect: SQLITE, DSN: "test"})
+	dealError(t, err)
+	if !driver.deadline {
+		t.Fatal("driver did not receive the context deadline")
+	}
+
+	//取消的context使连接失败
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = hormManager.ConnectConfigContext(ctx, &ConnConfig{Driver: "horm_ctx_test", Dialect: SQLITE, DSN: "test"})
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("connect with canceled context got %v", err)
+	}
+	_, err = hormManager.ConnectDSNContext(ctx, SQLITE, ":memory:")
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("connect dsn with canceled context got %v", err)
+	}
+}
+
+func TestPoolConfig(t *testing.T) {
+	hormManager := New()
+	did, err := hormManager.ConnectConfig(&ConnConfig{Driver: SQLITE, DbName: t.TempDir() + "/pool.db", Pool: &PoolConfig{MaxOpenConns: 3}})
+	dealError(t, err)
+	stats, err := hormManager.Stats(did)
+	dealError(t, err)
+	if stats.MaxOpenConnections != 3 {
+		t.Fatalf("max open connections=%d", stats.MaxOpenConnections)
+	}
+	dealError(t, hormManager.SetPoolConfig(did, &PoolConfig{MaxOpenConns: 5, ConnMaxLifetime: time.Minute}))
+	stats, err = hormManager.Stats(did)
+	dealError(t, err)
+	if stats.MaxOpenConnections != 5 {
+		t.Fatalf("max open connections=%d", stats.MaxOpenConnections)
+	}
+	_, err = hormManager.Stats(-1)
+	if err == nil {
+		t.Fatal("stats of unknown connection should fail")
+	}
+	if err = hormManager.SetPoolConfig(did, nil); err == nil {
+		t.Fatal("set nil pool config should fail")
+	}
+}
+
+func TestNamedConnection(t *testing.T) {
+	hormManager := New()
+	orders, err := hormManager.ConnectConfig(&ConnConfig{Name: "orders", Driver: SQLITE, DbName: ":memory:"})
+	dealError(t, err)
+	reporting, err := hormManager.ConnectConfig(&ConnConfig{Name: "reporting", Driver: SQLITE, DbName: ":memory:"})
+	dealError(t, err)
+	if orders == reporting {
+		t.Fatalf("did collision %d", orders)
+	}
+	_, err = hormManager.ConnectConfig(&ConnConfig{Name: "orders", Driver: SQLITE, DbName: ":memory:"})
+	if err == nil {
+		t.Fatal("connect with duplicate name should fail")
+	}
+
+	did, err := hormManager.Lookup("reporting")
+	dealError(t, err)
+	if did != reporting {
+		t.Fatalf("lookup reporting got %d, want %d", did, reporting)
+	}
+	horm, err := hormManager.CreateByName("orders")
+	dealError(t, err)
+	_, err = horm.Exec(createTestTable)
+	dealError(t, err)
+
+	//关闭单个连接
+	dealError(t, hormManager.CloseByName("orders"))
+	_, err = hormManager.CreateByName("orders")
+	if err == nil {
+		t.Fatal("create closed connection should fail")
+	}
+	_, err = hormManager.Stats(reporting)
+	dealError(t, err)
+	dealError(t, hormManager.Close(reporting))
+
+	//不存在或已关闭的连接,创建的horm所有操作都返回错误
+	for _, horm := range []IHorm{hormManager.Create(9999), hormManager.Create(reporting)} {
+		th := newTestHorm()
+		var ids []int64
+		_, saveErr := horm.Save(th)
+		_, execErr := horm.Exec(createTestTable)
+		for _, err := range []error{saveErr, horm.FindById(th), horm.List(&[]*testHorm{}), horm.Query("select id from test_horm", &ids), execErr, horm.Begin()} {
+			if err == nil || !strings.Contains(err.Error(), "not found") {
+				t.Fatalf("operation on missing connection got %v", err)
+			}
+		}
+	}
+}
+
+func TestReadWriteSplitting(t *testing.T) {
+	//主库和从库使用不同的数据库文件,通过记录数判断路由
+	hormManager := New()
+	dir := t.TempDir()
+	for _, name := range []string{"primary.db", "replica.db"} {
+		did, err := hormManager.ConnectDSN(SQLITE, dir+"/"+name)
+		dealError(t, err)
+		_, err = hormManager.Create(did).Exec(createTestTable)
+		dealError(t, err)
+		dealError(t, hormManager.Close(did))
+	}
+	did, err := hormManager.ConnectConfig(&ConnConfig{
+		Driver:   SQLITE,
+		DbName:   dir + "/primary.db",
+		Replicas: []*ReplicaConfig{{DSN: dir + "/replica.db"}},
+	})
+	dealError(t, err)
+	horm := hormManager.Create(did)
+
+	//写操作使用主库,查询使用从库
+	_, err = horm.Save(newTestHorm())
+	dealError(t, err)
+	count := 0
+	dealError(t, horm.Query("select count(*) from tb_test", &count))
+	if count != 0 {
+		t.Fatalf("replica count=%d", count)
+	}
+
+	//强制使用主库
+	dealError(t, horm.QueryContext(ForcePrimary(context.Background()), "select count(*) from tb_test", &count))
+	if count != 1 {
+		t.Fatalf("force primary count=%d", count)
+	}
+
+	//事务中使用主库
+	dealError(t, horm.Begin())
+	list := new([]testHorm)
+	dealError(t, horm.List(list))
+	dealError(t, horm.Commit())
+	if len(*list) != 1 {
+		t.Fatalf("list in transaction got %d records", len(*list))
+	}
+	dealError(t, hormManager.Close(did))
+
+	//按权重平滑轮询
+	conn := &connection{db: &sql.DB{}, replicas: []*replica{{db: &sql.DB{}, weight: 2}, {db: &sql.DB{}, weight: 1}}}
+	selected := map[*sql.DB]int{}
+	for j := 0; j < 6; j++ {
+		selected[conn.reader(context.Background())]++
+	}
+	if selected[conn.replicas[0].db] != 4 || selected[conn.replicas[1].db] != 2 {
+		t.Fatalf("weighted selection got %v", selected)
+	}
+}
+
+func TestShard(t *testing.T) {
+	hormManager := New()
+	err := SetDialect(SQLITE)
+	dealError(t, err)
+
+	//4张表平均分布在2个库中,_00和_01在第一个库,_02和_03在第二个库
+	var dids []int64
+	for j := 0; j < 2; j++ {
+		did, err := hormManager.Connect("", 0, "", "", ":memory:")
+		dealError(t, err)
+		defer hormManager.Close(did)
+		dids = append(dids, did)
+		for k := j * 2; k < j*2+2; k++ {
+			_, err = hormManager.Create(did).Exec(fmt.Sprintf("CREATE TABLE tb_order_%02d (id INTEGER PRIMARY KEY, user_id INTEGER, amount INTEGER)", k))
+			dealError(t, err)
+		}
+	}
+	testOrderRule = &ShardRule{Key: "user_id", Strategy: &ModStrategy{Dids: dids, Tables: 4}}
+	horm := hormManager.Create(dids[0])
+
+	//单条记录按分片键路由到对应的库和表
+	_, err = horm.Save(&testOrder{Id: 1, UserId: 3, Amount: 10})
+	dealError(t, err)
+	var count int
+	err = hormManager.Create(dids[1]).Query("select count(*) from tb_order_03", &count)
+	dealError(t, err)
+	if count != 1 {
+		t.Fatalf("tb_order_03 count=%d", count)
+	}
+	order := &testOrder{Id: 1, UserId: 3}
+	err = horm.FindById(order)
+	dealError(t, err)
+	if order.Amount != 10 {
+		t.Fatalf("find by id got %+v", order)
+	}
+
+	//批量插入按分片拆分
+	res, err := horm.SaveAll([]*testOrder{{Id: 2, UserId: 0, Amount: 20}, {Id: 3, UserId: 1, Amount: 30}, {Id: 4, UserId: 6, Amount: 40}})
+	dealError(t, err)
+	if res.RowsAffected != 3 {
+		t.Fatalf("save all result=%+v", res)
+	}
+
+	//没有分片键的查询遍历所有分片
+	var orders []testOrder
+	err = horm.List(&orders)
+	dealError(t, err)
+	if len(orders) != 4 {
+		t.Fatalf("list got %d orders", len(orders))
+	}
+
+	//事务中不能访问其他库的分片
+	err = horm.Begin()
+	dealError(t, err)
+	_, err = horm.Save(&testOrder{Id: 5, UserId: 2, Amount: 50})
+	if err == nil {
+		t.Fatal("save to another shard connection in transaction should fail")
+	}
+	dealError(t, horm.RollBack())
+
+	//多个分片时不支持排序和分页
+	err = horm.List(&orders, "id desc")
+	if err == nil {
+		t.Fatal("list multiple shards with order by should fail")
+	}
+	err = horm.List(&orders, "limit 1")
+	if err == nil {
+		t.Fatal("list multiple shards with limit should fail")
+	}
+
+	//不支持分片的sql生成器不能用于分片表,避免生成没有后缀的表名
+	generator := &testPlainGenerator{newDefaultSqlGenerator(defaultDialect, defaultMapper, &nopLogger{})}
+	plainManager := NewManager(&Options{Dialect: SQLITE, Generator: generator})
+	did, err := plainManager.Connect("", 0, "", "", ":memory:")
+	dealError(t, err)
+	err = plainManager.Create(did).FindById(&testOrder{Id: 1, UserId: 3})
+	if err == nil || !strings.Contains(err.Error(), "IShardSqlGenerator") {
+		t.Fatalf("find by id with plain generator got %v", err)
+	}
+	dealError(t, plainManager.CloseAll())
+}
+
+func TestShardStrategy(t *testing.T) {
+	//分表数为0时返回错误而不是除0
+	for _, strategy := range []ShardStrategy{&ModStrategy{}, &HashStrategy{}} {
+		if _, err := strategy.Shard(1); err == nil {
+			t.Fatalf("%T with 0 tables should fail", strategy)
+		}
+	}
+
+	//math.MinInt64取绝对值后不会得到负数的分片
+	mod := &ModStrategy{Tables: 3}
+	for value, want := range map[int64]string{math.MinInt64: "_02", -4: "_01", 4: "_01"} {
+		shard, err := mod.Shard(value)
+		dealError(t, err)
+		if shard.Suffix != want {
+			t.Fatalf("shard of %d got %s, want %s", value, shard.Suffix, want)
+		}
+	}
+
+	//指针分片键按指向的值计算,nil返回错误
+	hash := &HashStrategy{ModStrategy{Tables: 8}}
+	a, b := 5, 5
+	shardA, err := hash.Shard(&a)
+	dealError(t, err)
+	shardB, err := hash.Shard(&b)
+	dealError(t, err)
+	shard, err := hash.Shard(5)
+	dealError(t, err)
+	if shardA.Suffix != shardB.Suffix || shardA.Suffix != shard.Suffix {
+		t.Fatalf("pointer shard got %s %s, want %s", shardA.Suffix, shardB.Suffix, shard.Suffix)
+	}
+	if _, err = hash.Shard((*int)(nil)); err == nil {
+		t.Fatal("nil shard key should fail")
+	}
+}
+
+func TestManagerDialect(t *testing.T) {
+	//全局的方言和生成器不影响NewManager创建的管理器
+	dealError(t, SetDialect(POSTGRES))
+	defer SetDialect(SQLITE)
+	SetSqlGenerator(&testPlainGenerator{})
+	defer SetSqlGenerator(nil)
+	db, err := sql.Open(SQLITE, ":memory:")
+	dealError(t, err)
+	defer db.Close()
+	for dialect, want := range map[string]string{"": MYSQL, SQLITE: SQLITE} {
+		h, err := NewManager(&Options{Dialect: dialect}).WrapDB(db, "")
+		dealError(t, err)
+		if h.Dialect().Name() != want {
+			t.Fatalf("dialect got %s, want %s", h.Dialect().Name(), want)
+		}
+		if _, ok := h.(*defaultHorm).generator.(*testPlainGenerator); ok {
+			t.Fatal("manager should not use the global sql generator")
+		}
+	}
+
+	//全局管理器仍然使用全局的方言
+	h, err := New().WrapDB(db, "")
+	dealError(t, err)
+	if h.Dialect().Name() != POSTGRES {
+		t.Fatalf("global manager dialect got %s", h.Dialect().Name())
+	}
+	_, err = NewManager(&Options{Dialect: "unknown"}).Connect("", 0, "", "", ":memory:")
+	if err == nil {
+		t.Fatal("connect with unknown dialect should fail")
+	}
+}
+
+func TestNewManager(t *testing.T) {
+	//独立的管理器使用自己的标签名,命名策略和日志
+	buf := &bytes.Buffer{}
+	hormManager := NewManager(&Options{
+		Dialect:        SQLITE,
+		Logger:         log.New(buf, "", 0),
+		NamingStrategy: &SnakeNamingStrategy{TablePrefix: "tb_"},
+		TagName:        "db",
+	})
+	if hormManager == New() {
+		t.Fatal("NewManager should not return the global manager")
+	}
+	horm := newTestDB(t, hormManager)
+	defer hormManager.CloseAll()
+	_, err := horm.Exec("CREATE TABLE tb_test_naming (id INTEGER PRIMARY KEY AUTOINCREMENT, create_time DATETIME, description VARCHAR(255))")
+	dealError(t, err)
+
+	//没有标签的字段使用命名策略,GetTableName返回空时表名为tb_test_naming
+	th := &testNaming{CreateTime: time.Now().UTC().Truncate(time.Second), Description: "naming"}
+	res, err := horm.Save(th)
+	dealError(t, err)
+	th2 := &testNaming{ID: res.LastInsertId}
+	err = horm.FindById(th2)
+	dealError(t, err)
+	if th2.Description != th.Description || !th2.CreateTime.Equal(th.CreateTime) {
+		t.Fatalf("find by id got %+v, want %+v", th2, th)
+	}
+	if !strings.Contains(buf.String(), "INSERT INTO") {
+		t.Fatalf("logger got %q", buf.String())
+	}
+
+	//同一个结构体在不同的管理器中按各自的标签解析
+	si, err := hormManager.(*HormManager).mapper.getStuctInfo(&testHorm{})
+	dealError(t, err)
+	if si.pkColumnName != "" || si.columnFieldMap["id"] != "Id" {
+		t.Fatalf("struct info got %+v", si)
+	}
+	si, err = defaultMapper.getStuctInfo(&testHorm{})
+	dealError(t, err)
+	if si.pkColumnName != "id" || si.columnFieldMap["id"] != "" {
+		t.Fatalf("default struct info got %+v", si)
+	}
+	if toSnakeString("UserID") != "user_id" || toSnakeString("HTTPServer") != "http_server" {
+		t.Fatalf("snake string got %s %s", toSnakeString("UserID"), toSnakeString("HTTPServer"))
+	}
+}
+
+func TestWrap(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	dealError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	//包装已有的*sql.DB
+	horm, err := WrapDB(db, SQLITE)
+	dealError(t, err)
+	_, err = horm.Exec(createTestTable)
+	dealError(t, err)
+	_, err = horm.Save(newTestHorm())
+	dealError(t, err)
+
+	//包装已有的*sql.Tx,由调用方提交或回滚
+	tx, err := db.Begin()
+	dealError(t, err)
+	txHorm, err := New().WrapTx(tx, SQLITE)
+	dealError(t, err)
+	_, err = txHorm.Save(newTestHorm())
+	dealError(t, err)
+	if txHorm.Begin() == nil {
+		t.Fatal("begin on wrapped transaction should fail")
+	}
+	dealError(t, tx.Rollback())
+
+	//包装已有的*sql.Conn
+	conn, err := db.Conn(context.Background())
+	dealError(t, err)
+	defer conn.Close()
+	connHorm, err := New().WrapConn(conn, SQLITE)
+	dealError(t, err)
+	var count int
+	err = connHorm.Query("select count(*) from tb_test", &count)
+	dealError(t, err)
+	if count != 1 {
+		t.Fatalf("count=%d", count)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	hormManager := New()
+
+	//第一个主机不可用时切换到下一个主机
+	did, err := hormManager.ConnectConfig(&ConnConfig{
+		Driver:   SQLITE,
+		DSN:      "/nonexistent/horm.db",
+		Failover: []*HostConfig{{DSN: ":memory:"}, {DSN: ":memory:"}},
+		Replicas: []*ReplicaConfig{{DSN: ":memory:"}},
+	})
+	dealError(t, err)
+	defer hormManager.Close(did)
+	conn, err := hormManager.(*HormManager).getConnection(did)
+	dealError(t, err)
+	if conn.current != 1 {
+		t.Fatalf("current host=%d", conn.current)
+	}
+	dealError(t, hormManager.Ping(did))
+
+	//主库不可用时检查会切换到下一个主机
+	conn.primary().Close()
+	dealError(t, hormManager.Ping(did))
+	if conn.current != 2 {
+		t.Fatalf("current host=%d", conn.current)
+	}
+
+	//并发的检查只切换一次,跳过不可用的第一个主机
+	conn.primary().Close()
+	var wg sync.WaitGroup
+	for j := 0; j < 5; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hormManager.Ping(did)
+		}()
+	}
+	wg.Wait()
+	if conn.current != 1 {
+		t.Fatalf("current host=%d", conn.current)
+	}
+
+	//不可用的从库不再路由查询
+	conn.replicas[0].db.Close()
+	if hormManager.Ping(did) == nil {
+		t.Fatal("ping with closed replica should fail")
+	}
+	if conn.reader(context.Background()) != conn.primary() {
+		t.Fatal("reader should use primary when replicas are down")
+	}
 }
 
-func dealError(err error) {
+func TestShutdown(t *testing.T) {
+	hormManager := NewManager(&Options{Dialect: SQLITE})
+	horm := newTestDB(t, hormManager)
+
+	//关闭时等待事务结束,事务中的操作可以继续执行,新的操作被拒绝
+	err := horm.Begin()
+	dealError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- hormManager.Shutdown(context.Background())
+	}()
+	for !hormManager.(*HormManager).isClosing() {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = horm.Save(newTestHorm())
+	dealError(t, err)
+	go func() {
+		_, err := horm.Save(newTestHorm())
+		done <- err
+	}()
+	if err := <-done; err != errShutdown {
+		t.Fatalf("save outside transaction got %v", err)
+	}
+	dealError(t, horm.Commit())
+	dealError(t, <-done)
+	if _, err = hormManager.Connect("", 0, "", "", ":memory:"); err != errShutdown {
+		t.Fatalf("connect after shutdown got %v", err)
+	}
+
+	//超时后仍然关闭所有连接,返回汇总的错误
+	hormManager = NewManager(&Options{Dialect: SQLITE})
+	horm = newTestDB(t, hormManager)
+	err = horm.Begin()
+	dealError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = hormManager.Shutdown(ctx)
+	if err == nil || !strings.Contains(err.Error(), "wait for active sessions failed") {
+		t.Fatalf("shutdown got %v", err)
+	}
+	horm.RollBack()
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HORM_TEST_DSN", ":memory:")
+	files := map[string]string{
+		"horm.yaml": "log_level: warn\nslow_threshold: 1ns\nconnections:\n  - name: orders\n    driver: sqlite\n    dsn: \"${HORM_TEST_DSN}\"\n    password: pa$$word$1\n    pool:\n      max_open_conns: 1\n",
+		"horm.json": `{"log_level": "warn", "slow_threshold": "1ns", "connections": [{"name": "orders", "driver": "sqlite", "dsn": "${HORM_TEST_DSN}", "password": "pa$$word$1", "health_check_interval": 1000000000, "pool": {"max_open_conns": 1, "conn_max_lifetime": "1m"}}]}`,
+		"horm.toml": "log_level = \"warn\"\nslow_threshold = \"1ns\"\n[[connections]]\nname = \"orders\"\ndriver = \"sqlite\"\ndsn = \"${HORM_TEST_DSN}\"\npassword = \"pa$$word$1\"\n[connections.pool]\nmax_open_conns = 1\n",
+	}
+	for name, content := range files {
+		path := dir + "/" + name
+		dealError(t, os.WriteFile(path, []byte(content), 0644))
+		c, err := LoadConfig(path)
+		dealError(t, err)
+		if c.SlowThreshold != time.Nanosecond || len(c.Connections) != 1 || c.Connections[0].DSN != ":memory:" || c.Connections[0].Pool.MaxOpenConns != 1 {
+			t.Fatalf("%s got %+v", name, c)
+		}
+		//只替换${VAR},其他的$原样保留
+		if c.Connections[0].PassWord != "pa$$word$1" {
+			t.Fatalf("%s got password %s", name, c.Connections[0].PassWord)
+		}
+	}
+	c, err := LoadConfig(dir + "/horm.json")
+	dealError(t, err)
+	if c.Connections[0].HealthCheckInterval != time.Second {
+		t.Fatalf("json got health check interval %v", c.Connections[0].HealthCheckInterval)
+	}
+	if c.Connections[0].Pool.ConnMaxLifetime != time.Minute {
+		t.Fatalf("json got conn max lifetime %v", c.Connections[0].Pool.ConnMaxLifetime)
+	}
+	dealError(t, os.WriteFile(dir+"/bad.json", []byte(`{"slow_threshold": "1x"}`), 0644))
+	if _, err = LoadConfig(dir + "/bad.json"); err == nil || !strings.Contains(err.Error(), "slow_threshold") {
+		t.Fatalf("json with bad duration got %v", err)
+	}
+
+	//从环境变量加载
+	t.Setenv("HORM_LOG_LEVEL", "warn")
+	t.Setenv("HORM_SLOW_THRESHOLD", "1ns")
+	t.Setenv("HORM_CONNECTIONS", "orders,reporting")
+	t.Setenv("HORM_ORDERS_DRIVER", SQLITE)
+	t.Setenv("HORM_ORDERS_DSN", ":memory:")
+	t.Setenv("HORM_ORDERS_MAX_OPEN_CONNS", "1")
+	t.Setenv("HORM_REPORTING_DRIVER", SQLITE)
+	t.Setenv("HORM_REPORTING_DSN", ":memory:")
+	t.Setenv("HORM_REPORTING_PARAMS", "_pragma=foreign_keys(1)")
+	c, err = LoadConfigEnv("")
+	dealError(t, err)
+	if len(c.Connections) != 2 || c.Connections[0].Pool.MaxOpenConns != 1 || c.Connections[1].Params["_pragma"] != "foreign_keys(1)" {
+		t.Fatalf("env config got %+v", c)
+	}
+
+	//warn级别只输出慢查询
+	buf := &bytes.Buffer{}
+	hormManager, err := NewManagerFromConfig(c, &Options{Logger: log.New(buf, "", 0)})
+	dealError(t, err)
+	defer hormManager.CloseAll()
+	horm, err := hormManager.CreateByName("reporting")
+	dealError(t, err)
+	_, err = horm.Exec(createTestTable)
+	dealError(t, err)
+	if !strings.Contains(buf.String(), "slow sql") || strings.Contains(buf.String(), "transaction") {
+		t.Fatalf("logger got %q", buf.String())
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	hormManager := New()
+
+	//每次打开物理连接时获取凭证
+	calls := 0
+	credentials := CredentialsFunc(func(ctx context.Context) (string, string, error) {
+		calls++
+		return "", "", nil
+	})
+	did, err := hormManager.ConnectConfig(&ConnConfig{Driver: SQLITE, DbName: t.TempDir() + "/horm.db", Credentials: credentials})
+	dealError(t, err)
+	defer hormManager.Close(did)
+	if calls != 1 {
+		t.Fatalf("credentials calls=%d", calls)
+	}
+	dealError(t, hormManager.SetPoolConfig(did, &PoolConfig{MaxIdleConns: -1}))
+	_, err = hormManager.Create(did).Exec(createTestTable)
+	dealError(t, err)
+	if calls < 2 {
+		t.Fatalf("credentials calls=%d", calls)
+	}
+
+	//获取凭证失败时连接失败
+	failed := CredentialsFunc(func(ctx context.Context) (string, string, error) {
+		return "", "", errors.New("vault unavailable")
+	})
+	_, err = hormManager.ConnectConfig(&ConnConfig{Driver: SQLITE, DbName: ":memory:", Credentials: failed})
+	if err == nil || !strings.Contains(err.Error(), "vault unavailable") {
+		t.Fatalf("connect got %v", err)
+	}
+
+	//环境变量和文件
+	t.Setenv("HORM_TEST_PASSWORD", "env-secret")
+	_, passWord, err := (&EnvCredentials{PassWordVar: "HORM_TEST_PASSWORD"}).Credentials(context.Background())
+	dealError(t, err)
+	if passWord != "env-secret" {
+		t.Fatalf("env password=%s", passWord)
+	}
+	path := t.TempDir() + "/password"
+	dealError(t, os.WriteFile(path, []byte("file-secret\n"), 0600))
+	_, passWord, err = (&FileCredentials{PassWordFile: path}).Credentials(context.Background())
+	dealError(t, err)
+	if passWord != "file-secret" {
+		t.Fatalf("file password=%s", passWord)
+	}
+}
+
+func TestNullable(t *testing.T) {
+	hormManager := New()
+	horm := newTestDB(t, hormManager)
+	_, err := horm.Exec("CREATE TABLE tb_nullable (id INTEGER PRIMARY KEY AUTOINCREMENT, age INTEGER, name VARCHAR(20), birthday DATETIME, nick VARCHAR(20), score INTEGER, login_time DATETIME, state INTEGER)")
+	dealError(t, err)
+
+	//nil指针和无效的sql.Null类型保存为NULL,读取NULL时设置为零值
+	res, err := horm.Save(&testNullable{})
+	dealError(t, err)
+	n := &testNullable{Id: res.LastInsertId, Age: new(int), State: 1}
+	err = horm.FindById(n)
+	dealError(t, err)
+	if n.Age != nil || n.Name != nil || n.Birthday != nil || n.Nick.Valid || n.Score.Valid || n.LoginTime.Valid || n.State != 0 {
+		t.Fatalf("find null got %+v", n)
+	}
+
+	//有值时正常读写
+	age, name, now := 18, "horm", time.Now().UTC().Truncate(time.Second)
+	v := &testNullable{Age: &age, Name: &name, Birthday: &now, Nick: sql.NullString{String: "h", Valid: true}, Score: sql.NullInt64{Int64: 99, Valid: true}, LoginTime: sql.NullTime{Time: now, Valid: true}, State: 2}
+	res, err = horm.Save(v)
+	dealError(t, err)
+	n = &testNullable{Id: res.LastInsertId}
+	err = horm.FindById(n)
+	dealError(t, err)
+	if *n.Age != age || *n.Name != name || !n.Birthday.Equal(now) || n.Nick != v.Nick || n.Score != v.Score || !n.LoginTime.Time.Equal(now) || n.State != 2 {
+		t.Fatalf("find got %+v", n)
+	}
+	var list []testNullable
+	err = horm.List(&list, "age = 18")
+	dealError(t, err)
+	if len(list) != 1 || *list[0].Name != name {
+		t.Fatalf("list got %+v", list)
+	}
+
+	//主键为NULL时设置为零值
+	_, err = horm.Exec("CREATE TABLE tb_null_pk (id INTEGER, name VARCHAR(20))")
+	dealError(t, err)
+	_, err = horm.Exec("INSERT INTO tb_null_pk VALUES (NULL, 'a')")
+	dealError(t, err)
+	var nullPks []testNullPk
+	dealError(t, horm.List(&nullPks))
+	if len(nullPks) != 1 || nullPks[0].Id != nil || nullPks[0].Name != "a" {
+		t.Fatalf("null pk got %+v", nullPks)
+	}
+
+	//空字符串和NULL不同,多行读取时不会混淆
+	_, err = horm.Exec("CREATE TABLE tb_empty (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(20), nick VARCHAR(20), data BLOB)")
+	dealError(t, err)
+	_, err = horm.Exec("INSERT INTO tb_empty (name, nick, data) VALUES ('', '', x''), (NULL, NULL, NULL), ('', '', x'')")
+	dealError(t, err)
+	var empties []testEmpty
+	dealError(t, horm.List(&empties))
+	if len(empties) != 3 {
+		t.Fatalf("empty list got %+v", empties)
+	}
+	for j, e := range empties {
+		null := j == 1
+		if (e.Name == nil) != null || e.Nick.Valid == null || (e.Data == nil) != null || (e.Name != nil && *e.Name != "") || len(e.Data) != 0 {
+			t.Fatalf("row %d got %+v", j, e)
+		}
+	}
+}
+
+func TestScalarTypes(t *testing.T) {
+	hormManager := New()
+	horm := newTestDB(t, hormManager)
+	_, err := horm.Exec("CREATE TABLE tb_scalar (id INTEGER PRIMARY KEY AUTOINCREMENT, u INTEGER, u8 INTEGER, u64 INTEGER, i8 INTEGER, f32 REAL, b BOOLEAN, data BLOB, level INTEGER, label VARCHAR(20), note VARCHAR(50))")
+	dealError(t, err)
+
+	//无符号整数,float32,bool,[]byte,底层类型支持的自定义类型,带有引号和反斜杠的字符串
+	s := &testScalar{U: 7, U8: 255, U64: 1 << 62, I8: -128, F32: 1.5, B: true, Data: []byte{0, 1, 'x', 0xff}, Level: 3, Label: "vip", Note: `it's a \ test`}
+	res, err := horm.Save(s)
+	dealError(t, err)
+	s.Id = res.LastInsertId
+	s2 := &testScalar{Id: s.Id}
+	err = horm.FindById(s2)
+	dealError(t, err)
+	if s2.U != s.U || s2.U8 != s.U8 || s2.U64 != s.U64 || s2.I8 != s.I8 || s2.F32 != s.F32 || s2.B != s.B || !bytes.Equal(s2.Data, s.Data) || s2.Level != s.Level || s2.Label != s.Label || s2.Note != s.Note {
+		t.Fatalf("find by id got %+v, want %+v", s2, s)
+	}
+
+	//Query读取单个列时和结构体字段一样转换
+	var f float64
+	var u uint
+	var b bool
+	var dec Decimal
+	var at time.Time
+	var data []byte
+	var np *int
+	var ns sql.NullString
+	var fs []float64
+	for _, c := range []struct {
+		sql  string
+		dest interface{}
+		ok   func() bool
+	}{
+		{"select 1.25", &f, func() bool { return f == 1.25 }},
+		{"select 7", &u, func() bool { return u == 7 }},
+		{"select 1", &b, func() bool { return b }},
+		{"select '12.50'", &dec, func() bool { return dec.String() == "12.50" }},
+		{"select '2024-01-02 03:04:05'", &at, func() bool { return at.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)) }},
+		{"select x'00ff'", &data, func() bool { return bytes.Equal(data, []byte{0, 0xff}) }},
+		{"select NULL", &np, func() bool { return np == nil }},
+		{"select 'h'", &ns, func() bool { return ns.Valid && ns.String == "h" }},
+		{"select 1.5 union all select 2.5", &fs, func() bool { return len(fs) == 2 && fs[0] == 1.5 && fs[1] == 2.5 }},
+	} {
+		dealError(t, horm.Query(c.sql, c.dest))
+		if !c.ok() {
+			t.Fatalf("query %s got %v", c.sql, reflect.ValueOf(c.dest).Elem())
+		}
+	}
+	var m map[string]string
+	if err = horm.Query("select 1", &m); err == nil || !strings.Contains(err.Error(), "not support type") {
+		t.Fatalf("query into map got %v", err)
+	}
+	if err = horm.Query("select -1", &u); err == nil {
+		t.Fatal("query negative into uint should fail")
+	}
+
+	//读取时超出字段类型范围的整数返回错误,连接归还到连接池
+	_, err = horm.Exec("CREATE TABLE tb_overflow (id INTEGER PRIMARY KEY, i8 INTEGER, u8 INTEGER)")
+	dealError(t, err)
+	_, err = horm.Exec("INSERT INTO tb_overflow VALUES (1, 128, 0), (2, 0, 256), (3, 0, -1), (4, -128, 255)")
+	dealError(t, err)
+	for id, want := range map[int]string{1: "value out of range", 2: "value out of range", 3: "invalid syntax", 4: ""} {
+		o := &testOverflow{Id: id}
+		err = horm.FindById(o)
+		if (want == "" && err != nil) || (want != "" && (err == nil || !strings.Contains(err.Error(), want))) {
+			t.Fatalf("find overflow %d got %v", id, err)
+		}
+	}
+
+	//方言的字面量
+	mysql, _ := GetDialect(MYSQL)
+	if l := mysql.Literal(`'a\b'`); l != `'a\\b'` {
+		t.Fatalf("mysql literal got %s", l)
+	}
+	postgres, _ := GetDialect(POSTGRES)
+	if l := postgres.Literal("X'00ff'"); l != "decode('00ff', 'hex')" {
+		t.Fatalf("postgres literal got %s", l)
+	}
+}
+
+func TestValuerScanner(t *testing.T) {
+	hormManager := New()
+	horm := newTestDB(t, hormManager)
+	_, err := horm.Exec("CREATE TABLE tb_valuer (id INTEGER PRIMARY KEY AUTOINCREMENT, price VARCHAR(20), tags VARCHAR(50), no_tags VARCHAR(50))")
+	dealError(t, err)
+
+	//Value的返回值写入数据库,Scan使用驱动返回的原始值,nil指针为NULL
+	tags := testTags{"a", "b"}
+	v := &testValuer{Price: 1234, Tags: &tags}
+	res, err := horm.Save(v)
+	dealError(t, err)
+	var price string
+	err = horm.Query("select price from tb_valuer where id = ?", &price, res.LastInsertId)
+	dealError(t, err)
+	if price != "12.34" {
+		t.Fatalf("price got %s", price)
+	}
+	v2 := &testValuer{Id: int(res.LastInsertId)}
+	err = horm.FindById(v2)
+	dealError(t, err)
+	if v2.Price != v.Price || v2.Tags == nil || strings.Join(*v2.Tags, ",") != "a,b" || v2.NoTags != nil {
+		t.Fatalf("find by id got %+v", v2)
+	}
+
+	//Value返回错误
+	_, err = horm.Save(&testValuer{Price: -1})
+	if err == nil {
+		t.Fatal("save negative price should fail")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	hormManager := New()
+	horm := newTestDB(t, hormManager)
+	_, err := horm.Exec("CREATE TABLE tb_codec (id INTEGER PRIMARY KEY AUTOINCREMENT, meta TEXT, items TEXT, extra TEXT, blob BLOB)")
+	dealError(t, err)
+	RegisterCodec(&testGobCodec{})
+	t.Cleanup(func() { unregisterCodec("gob", "yaml") })
+
+	//map和切片编码为json,nil指针为NULL,自定义的编解码器编码为二进制
+	c := &testCodecRecord{Meta: map[string]string{"k": "it's"}, Items: []testItem{{Name: "a", Count: 1}}, Blob: &testItem{Name: "b", Count: 2}}
+	res, err := horm.Save(c)
+	dealError(t, err)
+	var meta string
+	dealError(t, horm.Query("select meta from tb_codec where id = ?", &meta, res.LastInsertId))
+	if meta != `{"k":"it's"}` {
+		t.Fatalf("meta got %s", meta)
+	}
+	c2 := &testCodecRecord{Id: int(res.LastInsertId)}
+	dealError(t, horm.FindById(c2))
+	if c2.Meta["k"] != "it's" || len(c2.Items) != 1 || c2.Items[0] != c.Items[0] || c2.Extra != nil || c2.Blob == nil || *c2.Blob != *c.Blob {
+		t.Fatalf("find by id got %+v", c2)
+	}
+
+	//没有注册的编解码器
+	_, err = horm.Save(&testUnknownCodec{})
+	if err == nil || !strings.Contains(err.Error(), "codec [yaml] not registered") {
+		t.Fatalf("unknown codec got %v", err)
+	}
+
+	//使用过结构体之后注册的编解码器也会生效
+	RegisterCodec(&testYamlCodec{})
+	res, err = horm.Save(&testUnknownCodec{Meta: testItem{Name: "v", Count: 3}})
+	dealError(t, err)
+	dealError(t, horm.Query("select meta from tb_codec where id = ?", &meta, res.LastInsertId))
+	if meta != "name: v\ncount: 3\n" {
+		t.Fatalf("yaml meta got %q", meta)
+	}
+
+	//不认识的选项
+	_, err = horm.Save(&testTypoOption{})
+	if err == nil || !strings.Contains(err.Error(), "unknown option [jsno]") {
+		t.Fatalf("typo option got %v", err)
+	}
+}
+
+func TestTime(t *testing.T) {
+	location := time.FixedZone("CST", 8*3600)
+	hormManager := NewManager(&Options{Dialect: SQLITE, Location: location, TimePrecision: 6, ZeroTimeAsNull: true})
+	horm := newTestDB(t, hormManager)
+	//sqlite驱动按UTC解析DATETIME列,使用TEXT列保存不带时区的时间
+	_, err := horm.Exec("CREATE TABLE tb_time (id INTEGER PRIMARY KEY AUTOINCREMENT, at TEXT, day TEXT, clock TEXT, zero TEXT)")
+	dealError(t, err)
+
+	//写入时转换到配置的时区并保留6位小数秒,date和time列只写入日期或时间,零值时间写入NULL
+	at := time.Date(2024, 1, 2, 20, 4, 5, 123456789, time.UTC)
+	tm := &testTime{At: at, Day: at, Clock: at}
+	res, err := horm.Save(tm)
+	dealError(t, err)
+	var row string
+	dealError(t, horm.Query("select at || '|' || day || '|' || clock || '|' || ifnull(zero, 'NULL') from tb_time where id = ?", &row, res.LastInsertId))
+	if row != "2024-01-03 04:04:05.123456|2024-01-03|04:04:05.123456|NULL" {
+		t.Fatalf("row got %s", row)
+	}
+
+	//读取时使用配置的时区
+	tm2 := &testTime{Id: int(res.LastInsertId)}
+	dealError(t, horm.FindById(tm2))
+	if !tm2.At.Equal(at.Truncate(time.Microsecond)) || tm2.At.Location() != location || !tm2.Day.Equal(time.Date(2024, 1, 3, 0, 0, 0, 0, location)) || !tm2.Zero.IsZero() {
+		t.Fatalf("find by id got %+v", tm2)
+	}
+
+	//驱动返回的时间保留时刻,转换到配置的时区
+	mapper := hormManager.(*HormManager).mapper
+	tokyo := time.Date(2024, 1, 3, 5, 4, 5, 0, time.FixedZone("JST", 9*3600))
+	c := &columnValue{}
+	dealError(t, c.Scan(tokyo))
+	var got time.Time
+	v := reflect.ValueOf(&got).Elem()
+	dealError(t, mapper.scanValue(&v, c, nil))
+	if !got.Equal(tokyo) || got.Location() != location {
+		t.Fatalf("driver time got %v", got)
+	}
+
+	//非法的精度
+	_, err = horm.Save(&testBadPrecision{})
+	if err == nil {
+		t.Fatal("invalid precision should fail")
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	hormManager := New()
+	horm := newTestDB(t, hormManager)
+	_, err := horm.Exec("CREATE TABLE tb_decimal (id INTEGER PRIMARY KEY AUTOINCREMENT, amount TEXT, price NUMERIC, rate DECIMAL(10,4), fee NUMERIC)")
+	dealError(t, err)
+
+	//超出float64精度的值保存为准确的字符串,声明了scale的字段读写时按小数位数四舍五入,浮点数不再截断为2位小数
+	amount, err := NewDecimal("12345678901234567.8901")
+	dealError(t, err)
+	price, err := NewDecimal("12.5")
+	dealError(t, err)
+	d := &testDecimal{Amount: amount, Price: &price, Rate: 0.12345, Fee: 0.1234}
+	res, err := horm.Save(d)
+	dealError(t, err)
+	var row string
+	dealError(t, horm.Query("select amount || '|' || rate || '|' || fee from tb_decimal where id = ?", &row, res.LastInsertId))
+	if row != "12345678901234567.8901|0.1235|0.1234" {
+		t.Fatalf("row got %s", row)
+	}
+	d2 := &testDecimal{Id: int(res.LastInsertId)}
+	dealError(t, horm.FindById(d2))
+	if d2.Amount.String() != "12345678901234567.8901" || d2.Price == nil || d2.Price.String() != "12.50" || d2.Rate != 0.1235 || d2.Fee != 0.1234 {
+		t.Fatalf("find by id got %+v", d2)
+	}
+
+	//四舍五入和big.Rat转换
+	n, err := NewDecimal("-1.005")
+	dealError(t, err)
+	if s := n.Round(2).String(); s != "-1.01" {
+		t.Fatalf("round got %s", s)
+	}
+	if s := DecimalFromRat(big.NewRat(1, 3), 4).String(); s != "0.3333" {
+		t.Fatalf("from rat got %s", s)
+	}
+	if _, err = NewDecimal("1.2.3"); err == nil {
+		t.Fatal("parse invalid decimal should fail")
+	}
+
+	//指数和小数位数超出范围
+	for _, s := range []string{"1e2000000000", "1e-2000000000", "1e1001", "0." + strings.Repeat("0", 1000) + "1"} {
+		if _, err = NewDecimal(s); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Fatalf("parse %.20s got %v", s, err)
+		}
+		if err = new(Decimal).Scan(s); err == nil {
+			t.Fatalf("scan %.20s should fail", s)
+		}
+	}
+	if n, err = NewDecimal("1e1000"); err != nil || n.Scale() != 0 || len(n.String()) != 1001 {
+		t.Fatalf("parse 1e1000 got %v", err)
+	}
+}
+
+func TestConverter(t *testing.T) {
+	hormManager := New()
+	horm := newTestDB(t, hormManager)
+	_, err := horm.Exec("CREATE TABLE tb_converter (id INTEGER PRIMARY KEY AUTOINCREMENT, status VARCHAR(20), roles VARCHAR(50))")
+	dealError(t, err)
+	RegisterConverter("test_status", EnumConverter{testStatusActive: "active", testStatusDisabled: "disabled"})
+	RegisterTypeConverter(testRoles{}, &testRolesConverter{})
+	t.Cleanup(func() { unregisterConverter("test_status", testRoles{}) })
+
+	//按名称注册的转换器通过conv选项使用,按类型注册的转换器用于该类型的所有字段
+	c := &testConverter{Status: testStatusDisabled, Roles: testRoles{"admin", "dev"}}
+	res, err := horm.Save(c)
+	dealError(t, err)
+	var row string
+	dealError(t, horm.Query("select status || '|' || roles from tb_converter where id = ?", &row, res.LastInsertId))
+	if row != "disabled|admin;dev" {
+		t.Fatalf("row got %s", row)
+	}
+	c2 := &testConverter{Id: int(res.LastInsertId)}
+	dealError(t, horm.FindById(c2))
+	if c2.Status != testStatusDisabled || strings.Join(c2.Roles, ",") != "admin,dev" {
+		t.Fatalf("find by id got %+v", c2)
+	}
+
+	//枚举中不存在的值
+	_, err = horm.Save(&testConverter{Status: 9})
+	if err == nil || !strings.Contains(err.Error(), "enum value [9] not found") {
+		t.Fatalf("unknown enum got %v", err)
+	}
+
+	//主键也使用转换器
+	_, err = horm.Exec("CREATE TABLE tb_converter_pk (status VARCHAR(20) PRIMARY KEY, roles VARCHAR(50))")
+	dealError(t, err)
+	_, err = horm.Save(&testConverterPk{Status: testStatusActive, Roles: testRoles{"ops"}})
+	dealError(t, err)
+	dealError(t, horm.Query("select status from tb_converter_pk", &row))
+	if row != "active" {
+		t.Fatalf("pk row got %s", row)
+	}
+	p := &testConverterPk{Status: testStatusActive}
+	dealError(t, horm.FindById(p))
+	if strings.Join(p.Roles, ",") != "ops" {
+		t.Fatalf("find by converted pk got %+v", p)
+	}
+	var pks []testConverterPk
+	dealError(t, horm.List(&pks))
+	if len(pks) != 1 || pks[0].Status != testStatusActive {
+		t.Fatalf("list got %+v", pks)
+	}
+
+	//不能比较的值和对应同一个字符串的多个值返回错误
+	if _, err = (EnumConverter{}).ToDB([]string{"a"}); err == nil || !strings.Contains(err.Error(), "not comparable") {
+		t.Fatalf("unhashable enum got %v", err)
+	}
+	if _, err = (EnumConverter{1: "a", 2: "a"}).FromDB("a"); err == nil || !strings.Contains(err.Error(), "more than one value") {
+		t.Fatalf("duplicate enum got %v", err)
+	}
+
+	//转换器收到的[]byte是复制的值,保留后不会被下一行覆盖
+	_, err = horm.Exec("CREATE TABLE tb_converter_raw (id INTEGER PRIMARY KEY AUTOINCREMENT, data BLOB)")
+	dealError(t, err)
+	RegisterConverter("test_raw", &testRawConverter{})
+	t.Cleanup(func() { unregisterConverter("test_raw") })
+	for _, s := range []string{"first", "second"} {
+		_, err = horm.Exec("INSERT INTO tb_converter_raw (data) VALUES (?)", []byte(s))
+		dealError(t, err)
+	}
+	var raws []testConverterRaw
+	dealError(t, horm.List(&raws))
+	if len(raws) != 2 || string(raws[0].Data) != "first" || string(raws[1].Data) != "second" {
+		t.Fatalf("raw list got %+v", raws)
+	}
+
+	//转换结果只在底层类型相同或者同类数字之间转换,溢出和其他类型返回错误
+	var s string
+	var i8 int8
+	var label testLabel
+	for _, c := range []struct {
+		value interface{}
+		dest  interface{}
+		err   string
+	}{
+		{int64(65), &s, "can not set to [string]"},
+		{int64(300), &i8, "overflows [int8]"},
+		{uint64(1 << 63), &i8, "overflows [int8]"},
+		{int64(100), &i8, ""},
+		{"vip", &label, ""},
+	} {
+		v := reflect.ValueOf(c.dest).Elem()
+		err = scanByConverter(&v, nil, &testFixedConverter{value: c.value})
+		if (c.err == "" && err != nil) || (c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err))) {
+			t.Fatalf("convert %T(%v) to %s got %v", c.value, c.value, v.Type(), err)
+		}
+	}
+	if i8 != 100 || label != "vip" {
+		t.Fatalf("converted got %d %s", i8, label)
+	}
+
+	//重新注册的转换器替换原来的转换器,已经解析过的结构体重新解析
+	RegisterConverter("test_status", EnumConverter{testStatusActive: "on", testStatusDisabled: "off"})
+	res, err = horm.Save(&testConverter{Status: testStatusDisabled})
+	dealError(t, err)
+	dealError(t, horm.Query("select status from tb_converter where id = ?", &row, res.LastInsertId))
+	if row != "off" {
+		t.Fatalf("re-registered converter got %s", row)
+	}
+}
+
+//删除测试注册的转换器,name为字符串时按名称删除,否则按类型删除
+func unregisterConverter(names ...interface{}) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for _, name := range names {
+		if s, ok := name.(string); ok {
+			delete(converterMap, s)
+		} else {
+			delete(typeConverterMap, reflect.TypeOf(name))
+		}
+	}
+	registryVersion++
+}
+
+//删除测试注册的编解码器
+func unregisterCodec(names ...string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for _, name := range names {
+		delete(codecMap, name)
+	}
+	registryVersion++
+}
+
+//连接sqlite内存数据库并创建测试表
+func newTestDB(t *testing.T, hormManager IHormManager) IHorm {
+	err := SetDialect(SQLITE)
+	dealError(t, err)
+	did, err := hormManager.Connect("", 0, "", "", ":memory:")
+	dealError(t, err)
+	horm := hormManager.Create(did)
+	_, err = horm.Exec(createTestTable)
+	dealError(t, err)
+	return horm
+}
+
+func dealError(t *testing.T, err error) {
+	t.Helper()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 }
 
@@ -123,5 +1476,390 @@ func (t *testHorm) GetTableName() string {
 }
 
 func newTestHorm() *testHorm {
-	return &testHorm{CreateTime: time.Now(), ModifyTime: time.Now(), State: 0, Type: 0, Description: "测试horm"}
+	now := time.Now().UTC().Truncate(time.Second)
+	return &testHorm{CreateTime: now, ModifyTime: now, State: 0, Type: 0, Description: "测试horm"}
+}
+
+type testKeyword struct {
+	Id    int    `field:"id,pk,auto"`
+	Order int    `field:"order"`
+	Desc  string `field:"desc"`
+}
+
+func (t *testKeyword) GetTableName() string {
+	return "tb-keyword"
+}
+
+type testNaming struct {
+	ID          int `db:"id,pk,auto"`
+	CreateTime  time.Time
+	Description string
+	Ignored     string `db:"-"`
+}
+
+func (t *testNaming) GetTableName() string {
+	return ""
+}
+
+type testNullable struct {
+	Id        int            `field:"id,pk,auto"`
+	Age       *int           `field:"age"`
+	Name      *string        `field:"name"`
+	Birthday  *time.Time     `field:"birthday"`
+	Nick      sql.NullString `field:"nick"`
+	Score     sql.NullInt64  `field:"score"`
+	LoginTime sql.NullTime   `field:"login_time"`
+	State     int            `field:"state"`
+}
+
+func (t *testNullable) GetTableName() string {
+	return "tb_nullable"
+}
+
+type testEmpty struct {
+	Id   int            `field:"id,pk,auto"`
+	Name *string        `field:"name"`
+	Nick sql.NullString `field:"nick"`
+	Data []byte         `field:"data"`
+}
+
+func (t *testEmpty) GetTableName() string {
+	return "tb_empty"
+}
+
+type testLevel uint8
+
+type testLabel string
+
+type testScalar struct {
+	Id    int       `field:"id,pk,auto"`
+	U     uint      `field:"u"`
+	U8    uint8     `field:"u8"`
+	U64   uint64    `field:"u64"`
+	I8    int8      `field:"i8"`
+	F32   float32   `field:"f32"`
+	B     bool      `field:"b"`
+	Data  []byte    `field:"data"`
+	Level testLevel `field:"level"`
+	Label testLabel `field:"label"`
+	Note  string    `field:"note"`
+}
+
+func (t *testScalar) GetTableName() string {
+	return "tb_scalar"
+}
+
+//以分为单位的金额,数据库中保存为元
+type testMoney int64
+
+func (m testMoney) Value() (driver.Value, error) {
+	if m < 0 {
+		return nil, errors.New("negative money")
+	}
+	return fmt.Sprintf("%d.%02d", m/100, m%100), nil
+}
+
+func (m *testMoney) Scan(src interface{}) error {
+	s, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("can not scan %T into money", src)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*m = testMoney(math.Round(f * 100))
+	return nil
+}
+
+//逗号分隔的标签
+type testTags []string
+
+func (t *testTags) Value() (driver.Value, error) {
+	return strings.Join(*t, ","), nil
+}
+
+func (t *testTags) Scan(src interface{}) error {
+	*t = strings.Split(src.(string), ",")
+	return nil
+}
+
+type testValuer struct {
+	Id     int       `field:"id,pk,auto"`
+	Price  testMoney `field:"price"`
+	Tags   *testTags `field:"tags"`
+	NoTags *testTags `field:"no_tags"`
+}
+
+func (t *testValuer) GetTableName() string {
+	return "tb_valuer"
+}
+
+type testItem struct {
+	Name  string
+	Count int
+}
+
+type testCodecRecord struct {
+	Id    int               `field:"id,pk,auto"`
+	Meta  map[string]string `field:"meta,json"`
+	Items []testItem        `field:"items,json"`
+	Extra *testItem         `field:"extra,json"`
+	Blob  *testItem         `field:"blob,codec=gob"`
+}
+
+func (t *testCodecRecord) GetTableName() string {
+	return "tb_codec"
+}
+
+type testUnknownCodec struct {
+	Id   int      `field:"id,pk,auto"`
+	Meta testItem `field:"meta,codec=yaml"`
+}
+
+func (t *testUnknownCodec) GetTableName() string {
+	return "tb_codec"
+}
+
+type testTypoOption struct {
+	Id   int               `field:"id,pk,auto"`
+	Meta map[string]string `field:"meta,jsno"`
+}
+
+func (t *testTypoOption) GetTableName() string {
+	return "tb_codec"
+}
+
+type testYamlCodec struct{}
+
+func (y *testYamlCodec) Name() string {
+	return "yaml"
+}
+
+func (y *testYamlCodec) Marshal(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (y *testYamlCodec) Unmarshal(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+type testGobCodec struct{}
+
+func (g *testGobCodec) Name() string {
+	return "gob"
+}
+
+func (g *testGobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (g *testGobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type testTime struct {
+	Id    int       `field:"id,pk,auto"`
+	At    time.Time `field:"at"`
+	Day   time.Time `field:"day,date"`
+	Clock time.Time `field:"clock,time"`
+	Zero  time.Time `field:"zero"`
+}
+
+func (t *testTime) GetTableName() string {
+	return "tb_time"
+}
+
+type testBadPrecision struct {
+	Id int       `field:"id,pk,auto"`
+	At time.Time `field:"at,precision=10"`
+}
+
+func (t *testBadPrecision) GetTableName() string {
+	return "tb_time"
+}
+
+type testDecimal struct {
+	Id     int      `field:"id,pk,auto"`
+	Amount Decimal  `field:"amount"`
+	Price  *Decimal `field:"price,scale=2"`
+	Rate   float64  `field:"rate,scale=4"`
+	Fee    float64  `field:"fee"`
+}
+
+func (t *testDecimal) GetTableName() string {
+	return "tb_decimal"
+}
+
+type testStatus int
+
+const (
+	testStatusActive testStatus = iota + 1
+	testStatusDisabled
+)
+
+//分号分隔的角色
+type testRoles []string
+
+type testRolesConverter struct{}
+
+func (r *testRolesConverter) ToDB(value interface{}) (interface{}, error) {
+	return strings.Join(value.(testRoles), ";"), nil
+}
+
+func (r *testRolesConverter) FromDB(src interface{}) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	return testRoles(strings.Split(src.(string), ";")), nil
+}
+
+type testConverter struct {
+	Id     int        `field:"id,pk,auto"`
+	Status testStatus `field:"status,conv=test_status"`
+	Roles  testRoles  `field:"roles"`
+}
+
+func (t *testConverter) GetTableName() string {
+	return "tb_converter"
+}
+
+type testOverflow struct {
+	Id int   `field:"id,pk"`
+	I8 int8  `field:"i8"`
+	U8 uint8 `field:"u8"`
+}
+
+func (t *testOverflow) GetTableName() string {
+	return "tb_overflow"
+}
+
+type testNoPk struct {
+	Name string `field:"name"`
+}
+
+func (t *testNoPk) GetTableName() string {
+	return "tb_no_pk"
+}
+
+type testNullPk struct {
+	Id   *int   `field:"id,pk"`
+	Name string `field:"name"`
+}
+
+func (t *testNullPk) GetTableName() string {
+	return "tb_null_pk"
+}
+
+type testConverterPk struct {
+	Status testStatus `field:"status,pk,conv=test_status"`
+	Roles  testRoles  `field:"roles"`
+}
+
+func (t *testConverterPk) GetTableName() string {
+	return "tb_converter_pk"
+}
+
+//原样返回驱动的值
+type testRawConverter struct{}
+
+func (r *testRawConverter) ToDB(value interface{}) (interface{}, error) {
+	return value, nil
+}
+
+func (r *testRawConverter) FromDB(src interface{}) (interface{}, error) {
+	return src, nil
+}
+
+//读取时返回固定的值
+type testFixedConverter struct {
+	value interface{}
+}
+
+func (f *testFixedConverter) ToDB(value interface{}) (interface{}, error) {
+	return value, nil
+}
+
+func (f *testFixedConverter) FromDB(src interface{}) (interface{}, error) {
+	return f.value, nil
+}
+
+type testConverterRaw struct {
+	Id   int    `field:"id,pk,auto"`
+	Data []byte `field:"data,conv=test_raw"`
+}
+
+func (t *testConverterRaw) GetTableName() string {
+	return "tb_converter_raw"
+}
+
+//记录连接时是否收到context的deadline的驱动
+type testCtxDriver struct {
+	deadline bool
+}
+
+//同一个驱动名只能注册一次,多次运行测试时复用
+var testCtx = &testCtxDriver{}
+
+var testCtxOnce sync.Once
+
+func (d *testCtxDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("use connector")
+}
+
+func (d *testCtxDriver) OpenConnector(name string) (driver.Connector, error) {
+	return &testCtxConnector{driver: d}, nil
+}
+
+type testCtxConnector struct {
+	driver *testCtxDriver
+}
+
+func (c *testCtxConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, c.driver.deadline = ctx.Deadline()
+	return &testCtxConn{}, nil
+}
+
+func (c *testCtxConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type testCtxConn struct{}
+
+func (c *testCtxConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *testCtxConn) Close() error {
+	return nil
+}
+
+func (c *testCtxConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+//只实现了ISqlGenerator的生成器
+type testPlainGenerator struct {
+	ISqlGenerator
+}
+
+var testOrderRule *ShardRule
+
+type testOrder struct {
+	Id     int `field:"id,pk"`
+	UserId int `field:"user_id"`
+	Amount int `field:"amount"`
+}
+
+func (t *testOrder) GetTableName() string {
+	return "tb_order"
+}
+
+func (t *testOrder) GetShardRule() *ShardRule {
+	return testOrderRule
 }
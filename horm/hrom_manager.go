@@ -1,51 +1,354 @@
 package horm
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 type IHormManager interface {
-	Connect(url string, port int, userName string, passWord string, dbName string) (int64, error) //连接数据库
-	Create(int64) IHorm                                                                           //创建horm
-	CloseAll() error                                                                              //关闭数据库连接
+	Connect(url string, port int, userName string, passWord string, dbName string) (int64, error)                             //连接数据库
+	ConnectContext(ctx context.Context, url string, port int, userName string, passWord string, dbName string) (int64, error) //连接数据库
+	ConnectDSN(driverName string, dataSourceName string) (int64, error)                                                       //通过完整的数据源名称连接数据库
+	ConnectDSNContext(ctx context.Context, driverName string, dataSourceName string) (int64, error)                           //通过完整的数据源名称连接数据库
+	ConnectConfig(c *ConnConfig) (int64, error)                                                                               //通过连接配置连接数据库
+	ConnectConfigContext(ctx context.Context, c *ConnConfig) (int64, error)                                                   //通过连接配置连接数据库
+	SetPoolConfig(did int64, c *PoolConfig) error                                                                             //设置主库和从库的连接池,c不能为nil
+	Stats(did int64) (sql.DBStats, error)                                                                                     //获取主库连接池统计信息
+	Ping(did int64) error                                                                                                     //检查主库和从库,主库不可用时切换到下一个主机
+	PingContext(ctx context.Context, did int64) error                                                                         //检查主库和从库,主库不可用时切换到下一个主机
+	Create(int64) IHorm                                                                                                       //创建horm
+	CreateByName(name string) (IHorm, error)                                                                                  //根据连接名创建horm
+	Lookup(name string) (int64, error)                                                                                        //根据连接名获取did
+	WrapDB(db *sql.DB, dialect string) (IHorm, error)                                                                         //使用已有的*sql.DB创建horm,方言为空时使用默认方言
+	WrapConn(conn *sql.Conn, dialect string) (IHorm, error)                                                                   //使用已有的*sql.Conn创建horm,方言为空时使用默认方言
+	WrapTx(tx *sql.Tx, dialect string) (IHorm, error)                                                                         //使用已有的*sql.Tx创建horm,方言为空时使用默认方言
+	Close(did int64) error                                                                                                    //关闭单个数据库连接
+	CloseByName(name string) error                                                                                            //根据连接名关闭数据库连接
+	CloseAll() error                                                                                                          //关闭数据库连接
+	Shutdown(ctx context.Context) error                                                                                       //停止接受新的操作,等待正在执行的操作和事务结束后关闭所有连接
 }
 
 type HormManager struct {
-	dbMap    map[int64]*sql.DB
+	dbMap    map[int64]*connection
+	nameMap  map[string]int64 //连接名->did
+	didSeq   int64            //did序列
 	hormList []IHorm
+	options  Options       //管理器的配置,传递给创建的每个horm
+	mapper   *structMapper //结构体映射器,每个管理器独立缓存
+	global   bool          //New创建的全局管理器,使用SetDialect和SetSqlGenerator的全局设置
+	mutex    sync.RWMutex
+
+	closing      bool          //关闭中,不再接受新的连接,操作和事务
+	active       int64         //正在执行的操作和事务数
+	drained      chan struct{} //关闭中所有操作和事务结束时关闭
+	sessionMutex sync.Mutex
 }
 
 func (m *HormManager) Connect(url string, port int, userName string, passWord string, dbName string) (int64, error) {
-	db, err := sql.Open(MYSQL, userName+":"+passWord+"@tcp("+url+":"+strconv.Itoa(port)+")/"+dbName)
+	return m.ConnectContext(context.Background(), url, port, userName, passWord, dbName)
+}
+
+func (m *HormManager) ConnectContext(ctx context.Context, url string, port int, userName string, passWord string, dbName string) (int64, error) {
+	return m.ConnectConfigContext(ctx, &ConnConfig{Host: url, Port: port, UserName: userName, PassWord: passWord, DbName: dbName})
+}
+
+func (m *HormManager) ConnectDSN(driverName string, dataSourceName string) (int64, error) {
+	return m.ConnectDSNContext(context.Background(), driverName, dataSourceName)
+}
+
+func (m *HormManager) ConnectDSNContext(ctx context.Context, driverName string, dataSourceName string) (int64, error) {
+	return m.ConnectConfigContext(ctx, &ConnConfig{Driver: driverName, DSN: dataSourceName})
+}
+
+func (m *HormManager) ConnectConfig(c *ConnConfig) (int64, error) {
+	return m.ConnectConfigContext(context.Background(), c)
+}
+
+func (m *HormManager) ConnectConfigContext(ctx context.Context, c *ConnConfig) (int64, error) {
+	if m.isClosing() {
+		return 0, errShutdown
+	}
+	dialect, err := m.getDialect()
 	if err != nil {
-		panic(errors.New("Not connected to the database:" + err.Error()))
+		return 0, err
+	}
+	conn, err := openConnection(ctx, c, dialect)
+	if err != nil {
+		return 0, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.nameMap[c.Name]; ok && c.Name != "" {
+		conn.close()
+		return 0, fmt.Errorf("connection [%s] already exists", c.Name)
+	}
+	m.didSeq++
+	did := m.didSeq
+	m.dbMap[did] = conn
+	if c.Name != "" {
+		m.nameMap[c.Name] = did
+	}
+	conn.logger = m.levelLogger(LOG_WARN)
+	if c.HealthCheckInterval > 0 {
+		conn.startHealthCheck(c.HealthCheckInterval)
 	}
-	did := time.Now().UnixNano()
-	m.dbMap[did] = db
 	return did, nil
 }
 
 func (m *HormManager) Create(did int64) IHorm {
-	return newDefaultHorm(m.dbMap[did])
+	conn, err := m.getConnection(did)
+	if err != nil {
+		dialect, _ := m.getDialect()
+		h := newDefaultHorm(&connection{dialect: dialect}, m)
+		h.err = err //连接不存在或者已经关闭时,所有操作都返回错误
+		return h
+	}
+	return newDefaultHorm(conn, m)
+}
+
+func (m *HormManager) CreateByName(name string) (IHorm, error) {
+	if m.isClosing() {
+		return nil, errShutdown
+	}
+	did, err := m.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := m.getConnection(did)
+	if err != nil {
+		return nil, err
+	}
+	return newDefaultHorm(conn, m), nil
+}
+
+func (m *HormManager) WrapDB(db *sql.DB, dialect string) (IHorm, error) {
+	if db == nil {
+		return nil, errors.New("db can not be nil")
+	}
+	return m.wrap(&connection{db: db}, dialect)
+}
+
+func (m *HormManager) WrapConn(conn *sql.Conn, dialect string) (IHorm, error) {
+	if conn == nil {
+		return nil, errors.New("conn can not be nil")
+	}
+	return m.wrap(&connection{raw: conn}, dialect)
+}
+
+func (m *HormManager) WrapTx(tx *sql.Tx, dialect string) (IHorm, error) {
+	if tx == nil {
+		return nil, errors.New("tx can not be nil")
+	}
+	return m.wrap(&connection{raw: tx}, dialect)
+}
+
+//使用包装的连接创建horm,包装的连接不由管理器管理,需要调用方关闭
+func (m *HormManager) wrap(conn *connection, dialect string) (IHorm, error) {
+	var err error
+	conn.dialect, err = m.getDialect()
+	if err != nil {
+		return nil, err
+	}
+	if dialect != "" {
+		d, err := GetDialect(dialect)
+		if err != nil {
+			return nil, err
+		}
+		conn.dialect = d
+	}
+	return newDefaultHorm(conn, m), nil
+}
+
+func (m *HormManager) Lookup(name string) (int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	did, ok := m.nameMap[name]
+	if !ok {
+		return 0, fmt.Errorf("connection [%s] not found", name)
+	}
+	return did, nil
 }
 
+func (m *HormManager) SetPoolConfig(did int64, c *PoolConfig) error {
+	if c == nil {
+		return errors.New("pool config can not be nil")
+	}
+	conn, err := m.getConnection(did)
+	if err != nil {
+		return err
+	}
+	for _, db := range conn.dbs() {
+		c.apply(db)
+	}
+	if conn.config != nil {
+		conn.mutex.Lock()
+		config := *conn.config
+		config.Pool = c //故障转移时使用新的连接池配置
+		conn.config = &config
+		conn.mutex.Unlock()
+	}
+	return nil
+}
+
+func (m *HormManager) Stats(did int64) (sql.DBStats, error) {
+	conn, err := m.getConnection(did)
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	return conn.primary().Stats(), nil
+}
+
+func (m *HormManager) Ping(did int64) error {
+	return m.PingContext(context.Background(), did)
+}
+
+func (m *HormManager) PingContext(ctx context.Context, did int64) error {
+	conn, err := m.getConnection(did)
+	if err != nil {
+		return err
+	}
+	return conn.check(ctx)
+}
+
+//根据did获取连接
+func (m *HormManager) getConnection(did int64) (*connection, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	conn := m.dbMap[did]
+	if conn == nil {
+		return nil, fmt.Errorf("connection [%d] not found", did)
+	}
+	return conn, nil
+}
+
+//获取管理器的日志,未设置时使用默认日志
+func (m *HormManager) logger() ILogger {
+	if m.options.Logger == nil {
+		return &defaultLogger{}
+	}
+	return m.options.Logger
+}
+
+//获取对应级别的日志,低于管理器的日志级别时不输出
+func (m *HormManager) levelLogger(level LogLevel) ILogger {
+	if level < m.options.LogLevel {
+		return &nopLogger{}
+	}
+	return m.logger()
+}
+
+func (m *HormManager) Close(did int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	conn := m.dbMap[did]
+	if conn == nil {
+		return fmt.Errorf("connection [%d] not found", did)
+	}
+	return m.close(did, conn)
+}
+
+func (m *HormManager) CloseByName(name string) error {
+	did, err := m.Lookup(name)
+	if err != nil {
+		return err
+	}
+	return m.Close(did)
+}
+
+//关闭所有连接,某个连接关闭失败时继续关闭其他连接,返回汇总的错误
 func (m *HormManager) CloseAll() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	errs := make([]string, 0)
 	for k, v := range m.dbMap {
-		err := v.Close()
+		err := m.close(k, v)
 		if err != nil {
-			return errors.New("Connection closed failed:" + err.Error())
+			errs = append(errs, fmt.Sprintf("[%d] %s", k, err.Error()))
 		}
-		log.Printf("[horm]:Horm-Connection[%d] is closed.\n", k)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
 	}
 	return nil
 }
 
+func (m *HormManager) Shutdown(ctx context.Context) error {
+	m.sessionMutex.Lock()
+	m.closing = true
+	drained := m.drained
+	if m.active > 0 && drained == nil {
+		drained = make(chan struct{})
+		m.drained = drained
+	}
+	m.sessionMutex.Unlock()
+	errs := make([]string, 0)
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			errs = append(errs, "wait for active sessions failed -> "+ctx.Err().Error())
+		}
+	}
+	err := m.CloseAll()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var errShutdown = errors.New("horm manager is shutting down")
+
+//是否关闭中
+func (m *HormManager) isClosing() bool {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	return m.closing
+}
+
+//开始一个操作或事务,关闭中时拒绝新的操作,inTx为true时允许事务中的操作继续执行
+func (m *HormManager) acquire(inTx bool) error {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	if m.closing && !inTx {
+		return errShutdown
+	}
+	m.active++
+	return nil
+}
+
+//结束一个操作或事务
+func (m *HormManager) release() {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	m.active--
+	if m.active == 0 && m.drained != nil {
+		close(m.drained)
+		m.drained = nil
+	}
+}
+
+//关闭连接并移除,调用时需要持有锁
+func (m *HormManager) close(did int64, conn *connection) error {
+	delete(m.dbMap, did)
+	if conn.name != "" {
+		delete(m.nameMap, conn.name)
+	}
+	err := conn.close()
+	if err != nil {
+		return errors.New("Connection closed failed:" + err.Error())
+	}
+	m.levelLogger(LOG_INFO).Printf("Horm-Connection[%d] is closed.", did)
+	return nil
+}
+
 var hormManager IHormManager = nil
 var lock sync.Mutex
 
@@ -53,15 +356,82 @@ var lock sync.Mutex
 func New() IHormManager {
 	lock.Lock()
 	if hormManager == nil {
-		hormManager = &HormManager{dbMap: make(map[int64]*sql.DB)}
+		hormManager = &HormManager{dbMap: make(map[int64]*connection), nameMap: make(map[string]int64), mapper: defaultMapper, global: true}
 	}
 	lock.Unlock()
 	return hormManager
 }
 
-//创建默认的Horm
-func newDefaultHorm(db *sql.DB) IHorm {
-	return &defaultHorm{db: db, mappings: newResultMap(), txMap: make(map[uint64]*sql.Tx)}
+//创建一个独立的horm管理器,opts为nil时使用默认配置
+func NewManager(opts *Options) IHormManager {
+	m := &HormManager{dbMap: make(map[int64]*connection), nameMap: make(map[string]int64)}
+	if opts != nil {
+		m.options = *opts
+	}
+	m.mapper = newStructMapper(m.options.TagName, m.options.NamingStrategy)
+	m.mapper.location = m.options.Location
+	m.mapper.timePrecision = m.options.TimePrecision
+	m.mapper.zeroTimeNull = m.options.ZeroTimeAsNull
+	return m
+}
+
+//管理器的默认方言,全局管理器使用SetDialect设置的方言,NewManager创建的管理器使用Options.Dialect
+func (m *HormManager) getDialect() (Dialect, error) {
+	if m.options.Dialect != "" {
+		return GetDialect(m.options.Dialect)
+	}
+	if m.global {
+		return defaultDialect, nil
+	}
+	return GetDialect(MYSQL)
+}
+
+//管理器的sql生成器,为nil时使用方言默认的生成器
+func (m *HormManager) generator() ISqlGenerator {
+	if m.options.Generator != nil {
+		return m.options.Generator
+	}
+	if m.global {
+		return sqlGenerator
+	}
+	return nil
+}
+
+//创建默认的Horm,使用管理器的配置,未设置sql生成器时使用方言对应的默认生成器
+func newDefaultHorm(conn *connection, manager *HormManager) *defaultHorm {
+	mapper := defaultMapper
+	var logger ILogger = &defaultLogger{}
+	var slowLogger ILogger = &defaultLogger{}
+	var slowThreshold time.Duration
+	generator := sqlGenerator
+	if manager != nil {
+		mapper = manager.mapper
+		logger = manager.levelLogger(LOG_DEBUG)
+		slowLogger = manager.levelLogger(LOG_WARN)
+		slowThreshold = manager.options.SlowThreshold
+		generator = manager.generator()
+	}
+	if generator == nil {
+		generator = newDefaultSqlGenerator(conn.dialect, mapper, logger)
+	}
+	return &defaultHorm{
+		conn:          conn,
+		manager:       manager,
+		dialect:       conn.dialect,
+		generator:     generator,
+		mapper:        mapper,
+		logger:        logger,
+		slowLogger:    slowLogger,
+		slowThreshold: slowThreshold,
+		mappings:      newResultMap(),
+		txMap:         make(map[uint64]*sql.Tx),
+		callbackMap:   make(map[uint64]*txCallbacks),
+	}
+}
+
+//使用已有的*sql.DB创建horm,使用全局的管理器
+func WrapDB(db *sql.DB, dialect string) (IHorm, error) {
+	return New().WrapDB(db, dialect)
 }
 
 func FastCreate(url string, port int, userName string, passWord string, dbName string) (IHorm, error) {
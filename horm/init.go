@@ -1,6 +1,16 @@
 package horm
 
+import "reflect"
+
 func init() {
-	SetSqlGenerator(&defaultSqlGenerator{})
-	structInfoMap = make(map[string]*StructInfo)
+	defaultMapper = newStructMapper(COLUMN_TAG, nil)
+	dialectMap = make(map[string]Dialect)
+	RegisterDialect(&mysqlDialect{})
+	RegisterDialect(&postgresDialect{})
+	RegisterDialect(&sqliteDialect{})
+	SetDialect(MYSQL)
+	codecMap = make(map[string]ICodec)
+	RegisterCodec(&jsonCodec{})
+	converterMap = make(map[string]IConverter)
+	typeConverterMap = make(map[reflect.Type]IConverter)
 }
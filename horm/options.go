@@ -0,0 +1,56 @@
+package horm
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+//horm管理器的配置,零值字段使用默认值
+type Options struct {
+	Dialect        string          //连接配置没有指定方言和驱动时使用的方言,默认为mysql,不受SetDialect影响
+	Generator      ISqlGenerator   //sql生成器,为nil时使用方言默认的生成器,不受SetSqlGenerator影响
+	Logger         ILogger         //日志,为nil时使用默认日志
+	NamingStrategy INamingStrategy //命名策略,为nil时只映射带有标签的字段
+	TagName        string          //列标签名,默认为field
+	LogLevel       LogLevel        //日志级别,默认为LOG_DEBUG
+	SlowThreshold  time.Duration   //慢查询阈值,执行时间超过阈值的sql以LOG_WARN级别输出,为0时不记录
+	Location       *time.Location  //读写时间使用的时区,为nil时使用time.Local,需要和数据库连接的时区一致
+	TimePrecision  int             //写入时间时秒的小数位数(0-9),如datetime(6)对应6,默认为0
+	ZeroTimeAsNull bool            //零值时间保存为NULL
+}
+
+//命名策略,用于没有标签的字段和GetTableName返回空的结构体
+type INamingStrategy interface {
+	TableName(structName string) string //根据结构体名获取表名
+	ColumnName(fieldName string) string //根据字段名获取列名
+}
+
+//下划线命名策略,CreateTime->create_time,表名可以加上前缀
+type SnakeNamingStrategy struct {
+	TablePrefix string //表名前缀,如tb_
+}
+
+func (s *SnakeNamingStrategy) TableName(structName string) string {
+	return s.TablePrefix + toSnakeString(structName)
+}
+
+func (s *SnakeNamingStrategy) ColumnName(fieldName string) string {
+	return toSnakeString(fieldName)
+}
+
+//驼峰转换为下划线,连续的大写字母作为一个单词,如UserID->user_id
+func toSnakeString(camelString string) string {
+	runes := []rune(camelString)
+	var b strings.Builder
+	for j, r := range runes {
+		if unicode.IsUpper(r) {
+			if j > 0 && (unicode.IsLower(runes[j-1]) || (j+1 < len(runes) && unicode.IsLower(runes[j+1]) && unicode.IsUpper(runes[j-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -2,38 +2,64 @@ package horm
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 //结构体信息
 type StructInfo struct {
-	tableName      string                          //表名
-	structFieldMap map[string]*reflect.StructField //字段名->字段反射信息
-	columnFieldMap map[string]string               //列名->字段名
-	pkField        *reflect.StructField            //主键
-	pkColumnName   string                          //主键字段名
-	pkAutoIncrease bool                            //主键是否自增长
+	tableName       string                          //表名
+	structFieldMap  map[string]*reflect.StructField //字段名->字段反射信息
+	columnFieldMap  map[string]string               //列名->字段名(不包含主键)
+	columnOptionMap map[string]*columnOption        //列名->标签选项,只包含有选项的列
+	pkField         *reflect.StructField            //主键
+	pkColumnName    string                          //主键字段名
+	pkAutoIncrease  bool                            //主键是否自增长
 }
 
 //结构体字段值
 type structValue struct {
-	value          *reflect.Value            //结构体的值
-	tableName      string                    //表名
-	fieldStringMap map[string]string         //列名->字符串值
-	fieldValueMap  map[string]*reflect.Value //列名->反射值
-	pkStringValue  string                    //主键字符串值
-	pkColumnName   string                    //主键的列名
-	autoIncrease   bool                      //是否自增长
+	value           *reflect.Value            //结构体的值
+	tableName       string                    //表名
+	fieldStringMap  map[string]string         //列名->字符串值
+	fieldValueMap   map[string]*reflect.Value //列名->反射值
+	columnOptionMap map[string]*columnOption  //列名->标签选项
+	pkStringValue   string                    //主键字符串值
+	pkColumnName    string                    //主键的列名
+	autoIncrease    bool                      //是否自增长
+	shard           *Shard                    //分片,没有分片时为nil
 }
 
-var structInfoMap map[string]*StructInfo
+//结构体映射器,根据标签名和命名策略解析结构体,并缓存解析结果
+type structMapper struct {
+	tagName       string                       //列标签名
+	naming        INamingStrategy              //命名策略,为nil时只映射带有标签的字段
+	structInfoMap map[reflect.Type]*StructInfo //结构体类型->结构体信息
+	location      *time.Location               //读写时间使用的时区,为nil时使用time.Local
+	timePrecision int                          //写入时间时秒的小数位数
+	zeroTimeNull  bool                         //零值时间是否保存为NULL
+	version       int                          //缓存对应的编解码器和转换器注册表版本
+	mutex         sync.RWMutex
+}
+
+//默认的结构体映射器,使用field标签
+var defaultMapper *structMapper
+
+func newStructMapper(tagName string, naming INamingStrategy) *structMapper {
+	if tagName == "" {
+		tagName = COLUMN_TAG
+	}
+	return &structMapper{tagName: tagName, naming: naming, structInfoMap: make(map[reflect.Type]*StructInfo)}
+}
 
 //获取结构体字段类型信息
-func getStuctInfo(i interface{}) (*StructInfo, error) {
+func (m *structMapper) getStuctInfo(i interface{}) (*StructInfo, error) {
 	t := reflect.TypeOf(i)
 
 	/*校验参数是否是指针或者切片,如果是,则获取指向的元素的反射类型信息,如果参数不是结构体指针或者切片,返回错误*/
@@ -46,8 +72,13 @@ func getStuctInfo(i interface{}) (*StructInfo, error) {
 		return nil, fmt.Errorf("[%s] is not struct", kind)
 	}
 
-	/*从缓存中获反射信息*/
-	if v, ok := structInfoMap[t.Name()]; ok {
+	/*从缓存中获反射信息,注册了新的编解码器或者转换器后缓存失效*/
+	version := currentRegistryVersion()
+	m.mutex.RLock()
+	v, ok := m.structInfoMap[t]
+	ok = ok && m.version == version
+	m.mutex.RUnlock()
+	if ok {
 		return v, nil
 	}
 
@@ -55,69 +86,96 @@ func getStuctInfo(i interface{}) (*StructInfo, error) {
 	pkColumnName := ""
 	auto := false
 	sfMap := make(map[string]*reflect.StructField)
+	cfMap := make(map[string]string)
+	coMap := make(map[string]*columnOption)
 
-	/*遍历结构体字段,保存带有field标签的字段类型信息*/
+	/*遍历结构体字段,保存带有标签的字段类型信息,没有标签的可导出字段使用命名策略获取列名*/
 	for j := 0; j < t.NumField(); j++ {
 		sf := t.Field(j)
-		tags := strings.Split(strings.TrimSpace(sf.Tag.Get(COLUMN_TAG)), ",")
-		if tags[0] != "" {
+		tags := strings.Split(strings.TrimSpace(sf.Tag.Get(m.tagName)), ",")
+		if tags[0] == "" && m.naming != nil && sf.PkgPath == "" && !sf.Anonymous {
+			tags[0] = m.naming.ColumnName(sf.Name)
+		}
+		if tags[0] != "" && tags[0] != "-" {
+			options := tags[1:]
 			if len(tags) >= 2 && tags[1] == "pk" {
 				primarayKeyField = &sf
 				pkColumnName = tags[0]
+				options = tags[2:]
 				if len(tags) >= 3 && tags[2] == "auto" {
 					auto = true
+					options = tags[3:]
 				}
 			} else {
 				sfMap[sf.Name] = &sf
+				cfMap[tags[0]] = sf.Name
+			}
+			option, err := parseColumnOption(options, sf.Type) //主键也可以使用转换器等选项
+			if err != nil {
+				return nil, fmt.Errorf("field [%s] -> %s", sf.Name, err.Error())
+			}
+			if option != nil {
+				coMap[tags[0]] = option
 			}
 		}
 	}
 
-	si := &StructInfo{structFieldMap: sfMap, pkField: primarayKeyField, pkColumnName: pkColumnName, pkAutoIncrease: auto}
+	si := &StructInfo{structFieldMap: sfMap, columnFieldMap: cfMap, columnOptionMap: coMap, pkField: primarayKeyField, pkColumnName: pkColumnName, pkAutoIncrease: auto}
 
-	/*通过table接口调用GetTableName方法获取表名*/
+	/*通过table接口调用GetTableName方法获取表名,表名为空时使用命名策略*/
 	if table, ok := i.(Table); ok {
 		si.tableName = table.GetTableName()
 	} else {
 		return nil, fmt.Errorf("[%s] did not implement the [Table] interface", t.Name())
 	}
+	if si.tableName == "" && m.naming != nil {
+		si.tableName = m.naming.TableName(t.Name())
+	}
 
-	structInfoMap[kind.String()] = si //存放结构体类型信息到缓存里
+	m.mutex.Lock()
+	if version > m.version {
+		m.structInfoMap = make(map[reflect.Type]*StructInfo)
+		m.version = version
+	}
+	if version == m.version {
+		m.structInfoMap[t] = si //存放结构体类型信息到缓存里
+	}
+	m.mutex.Unlock()
 	return si, nil
 }
 
 //获取结构体字段值
-func getStructValue(i interface{}) (*structValue, error) {
+func (m *structMapper) getStructValue(i interface{}) (*structValue, error) {
 	v := reflect.Indirect(reflect.ValueOf(i))
-	sf, err := getStuctInfo(i) //获取参数类型反射信息
+	sf, err := m.getStuctInfo(i) //获取参数类型反射信息
 	if err != nil {
 		return nil, fmt.Errorf("get [%s] struct info failed -> %s", v.Type().Name(), err.Error())
 	}
 	stringMap := make(map[string]string)
 	valueMap := make(map[string]*reflect.Value)
 
-	/*遍历结构体类型信息中保存的字段(过滤非field标签字段),并过滤非可导出的字段,获取字段的值*/
-	for fieldName, structField := range sf.structFieldMap {
+	/*遍历结构体类型信息中保存的字段(过滤没有映射的字段),并过滤非可导出的字段,获取字段的值*/
+	for column, fieldName := range sf.columnFieldMap {
 		value := v.FieldByName(fieldName)
 		if !value.CanSet() {
 			return nil, fmt.Errorf("field [%s] is unexported", fieldName)
 		}
-		convertedValue, err := convertString(value, value.Kind())
+		convertedValue, err := m.convertString(value, value.Kind(), sf.columnOptionMap[column])
 		if err != nil {
-			return nil, fmt.Errorf("convert [value=%s type=%s] error", value.Type(), value.Kind().String())
+			return nil, fmt.Errorf("convert [value=%s type=%s] error -> %s", value.Type(), value.Kind().String(), err.Error())
 		}
-		tags := strings.Split(strings.TrimSpace(structField.Tag.Get(COLUMN_TAG)), ",")
-		stringMap[tags[0]] = convertedValue
-		valueMap[tags[0]] = &value
+		stringMap[column] = convertedValue
+		valueMap[column] = &value
 	}
 
 	sv := &structValue{
-		value:          &v,
-		fieldValueMap:  valueMap,
-		fieldStringMap: stringMap,
-		tableName:      sf.tableName,
-		autoIncrease:   sf.pkAutoIncrease,
-		pkColumnName:   sf.pkColumnName,
+		value:           &v,
+		fieldValueMap:   valueMap,
+		columnOptionMap: sf.columnOptionMap,
+		fieldStringMap:  stringMap,
+		tableName:       sf.tableName,
+		autoIncrease:    sf.pkAutoIncrease,
+		pkColumnName:    sf.pkColumnName,
 	}
 
 	/*获取主键字段的值,校验主键字段是否可导出和是否是自增*/
@@ -127,7 +185,7 @@ func getStructValue(i interface{}) (*structValue, error) {
 		if !v.FieldByName(sf.pkField.Name).CanSet() {
 			return nil, fmt.Errorf("primary key [%s] is unexported", sf.pkField.Name)
 		}
-		pkStringValue, err := convertString(v.FieldByName(sf.pkField.Name), sf.pkField.Type.Kind())
+		pkStringValue, err := m.convertString(v.FieldByName(sf.pkField.Name), sf.pkField.Type.Kind(), sf.columnOptionMap[sf.pkColumnName])
 		if err != nil {
 			return nil, fmt.Errorf("convert id error:%s", err.Error())
 		}
@@ -147,75 +205,296 @@ func getSliceElem(list interface{}) (interface{}, error) {
 	return reflect.New(elementType).Interface(), nil
 }
 
-//转换反射值为字符串值
-func convertString(v reflect.Value, k reflect.Kind) (string, error) {
+//获取切片元素对应的结构体指针,元素可以是结构体或者结构体指针
+func getSliceStruct(list interface{}) (interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(list))
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("[%s] not a slice", v.Kind())
+	}
+	elementType := v.Type().Elem()
+	if elementType.Kind() == reflect.Ptr {
+		elementType = elementType.Elem()
+	}
+	return reflect.New(elementType).Interface(), nil
+}
+
+//获取结构体切片中每个元素的字段值,元素可以是结构体或者结构体指针
+func (m *structMapper) getStructValueList(list interface{}) ([]*structValue, error) {
+	v := reflect.Indirect(reflect.ValueOf(list))
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("[%s] not a slice", v.Kind())
+	}
+	structValues := make([]*structValue, 0, v.Len())
+	for j := 0; j < v.Len(); j++ {
+		e := v.Index(j)
+		if e.Kind() != reflect.Ptr {
+			e = e.Addr()
+		}
+		sv, err := m.getShardStructValue(e.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("get element [%d] struct value failed -> %s", j, err.Error())
+		}
+		structValues = append(structValues, sv)
+	}
+	return structValues, nil
+}
+
+//转换反射值为字符串值,nil指针和无效的sql.Null类型转换为NULL,实现了driver.Valuer的类型转换Value的返回值,option为列的标签选项,可以为nil
+func (m *structMapper) convertString(v reflect.Value, k reflect.Kind, option *columnOption) (string, error) {
+	if option != nil && option.converter != nil {
+		return m.convertByConverter(v, option)
+	}
+	if option != nil && option.codec != nil {
+		return encodeValue(v, option.codec)
+	}
+	if d, ok := getDecimal(v); ok && option != nil && option.scale >= 0 {
+		return quoteString(d.Round(option.scale).String()), nil
+	}
+	if valuer, ok := getValuer(v); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return "", fmt.Errorf("get [%s] value failed -> %s", v.Type(), err.Error())
+		}
+		if value == nil {
+			return "NULL", nil
+		}
+		//Value返回自身类型时按普通类型转换,避免无限递归
+		if rv := reflect.ValueOf(value); rv.Type() != v.Type() {
+			return m.convertString(rv, rv.Kind(), option)
+		}
+	}
 	switch k {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "NULL", nil
+		}
+		return m.convertString(v.Elem(), v.Elem().Kind(), option)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return strconv.Itoa(int(v.Int())), nil
-	case reflect.Float64:
-		return floatToString(v.Float()), nil
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		if option != nil && option.scale >= 0 {
+			return strconv.FormatFloat(v.Float(), 'f', option.scale, v.Type().Bits()), nil
+		}
+		return floatToString(v.Float(), v.Type().Bits()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
 	case reflect.String:
-		return "'" + v.String() + "'", nil
+		return quoteString(v.String()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.IsNil() {
+				return "NULL", nil
+			}
+			return "X'" + hex.EncodeToString(v.Bytes()) + "'", nil
+		}
 	case reflect.Struct:
+		if isSqlNull(v.Type()) {
+			if !v.Field(1).Bool() {
+				return "NULL", nil
+			}
+			return m.convertString(v.Field(0), v.Field(0).Kind(), option)
+		}
 		if t, ok := v.Interface().(time.Time); ok {
-			return t.Format("'2006-01-02 15:04:05'"), nil
+			return m.formatTime(t, option), nil
 		}
 	}
 	return "", fmt.Errorf("convert value to string error:not support type[%s]", v.Type().Name())
 }
 
-//通过反射设置一个字段的值
-func setValue(v *reflect.Value, rb sql.RawBytes) error {
-	k := v.Kind()
-	switch k {
-	case reflect.Int:
-		intValue, err := strconv.Atoi(string(rb))
+//转换为sql字符串字面量,单引号转义为两个单引号
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+//查询结果的列值,把驱动返回的各种类型统一转换为字节
+type columnValue struct {
+	bytes sql.RawBytes
+	src   interface{} //驱动返回的原始值,用于实现了sql.Scanner的字段
+	null  bool        //列值是否为NULL,空字符串的bytes也可能为nil,不能用bytes判断
+}
+
+func (c *columnValue) Scan(src interface{}) error {
+	c.src = src
+	c.null = src == nil
+	switch v := src.(type) {
+	case nil:
+		c.bytes = nil
+	case []byte:
+		c.bytes = append(c.bytes[:0], v...)
+	case string:
+		c.bytes = append(c.bytes[:0], v...)
+	case time.Time:
+		c.bytes = append(c.bytes[:0], v.Format(TIME_LAYOUT)...) //用于字符串字段,时间字段直接使用带有时区的src
+	default:
+		c.bytes = append(c.bytes[:0], fmt.Sprint(v)...)
+	}
+	return nil
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+//获取字段的driver.Valuer,值接收者和指针接收者的实现都可以,nil指针和sql.Null类型返回false
+func getValuer(v reflect.Value) (driver.Valuer, bool) {
+	if !v.IsValid() || !v.CanInterface() || isSqlNull(v.Type()) || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nil, false
+	}
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		return valuer, true
+	}
+	if v.CanAddr() {
+		if valuer, ok := v.Addr().Interface().(driver.Valuer); ok {
+			return valuer, true
+		}
+	}
+	return nil, false
+}
+
+//把查询结果的列值设置到字段,有编解码器的字段解码列值,实现了sql.Scanner的字段(sql.Null类型除外)使用驱动返回的原始值调用Scan
+func (m *structMapper) scanValue(v *reflect.Value, c *columnValue, option *columnOption) error {
+	if option != nil && option.converter != nil {
+		src := c.src
+		if b, ok := src.([]byte); ok {
+			src = append([]byte{}, b...) //驱动的缓冲区在下一次Next时会被覆盖,转换器可能保留这个值
+		}
+		return scanByConverter(v, src, option.converter)
+	}
+	if option != nil && option.codec != nil {
+		return decodeValue(v, c.bytes, c.null, option.codec)
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Ptr && t.Implements(scannerType) && !isSqlNull(t.Elem()) {
+		if c.src == nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		e := reflect.New(t.Elem())
+		err := e.Interface().(sql.Scanner).Scan(c.src)
 		if err != nil {
-			return fmt.Errorf("Set [%s] value failed:%s", k.String(), err)
+			return fmt.Errorf("scan [%s] failed -> %s", t, err.Error())
 		}
-		v.Set(reflect.ValueOf(intValue))
-	case reflect.Int8:
-		intValue, err := strconv.Atoi(string(rb))
+		v.Set(e)
+		roundDecimal(e.Elem(), option)
+		return nil
+	}
+	if t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(scannerType) && !isSqlNull(t) && v.CanAddr() {
+		err := v.Addr().Interface().(sql.Scanner).Scan(c.src)
 		if err != nil {
-			return err
+			return fmt.Errorf("scan [%s] failed -> %s", t, err.Error())
 		}
-		v.Set(reflect.ValueOf(int8(intValue)))
-	case reflect.Int16:
-		intValue, err := strconv.Atoi(string(rb))
+		roundDecimal(*v, option)
+		return nil
+	}
+	return m.setValue(v, c)
+}
+
+//是否可以作为单个列读取,即整数,浮点数,bool,字符串,[]byte,time.Time,sql.Null类型,实现了sql.Scanner的类型和它们的指针
+func isFieldType(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(scannerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	case reflect.Ptr:
+		return isFieldType(t.Elem())
+	case reflect.Struct:
+		return t == reflect.TypeOf(time.Time{}) || isSqlNull(t)
+	}
+	return false
+}
+
+//是否是sql.NullString,sql.NullInt64,sql.Null[T]等可以为NULL的类型,第一个字段是值,第二个字段是Valid
+func isSqlNull(t reflect.Type) bool {
+	return t.PkgPath() == "database/sql" && strings.HasPrefix(t.Name(), "Null") && t.NumField() == 2
+}
+
+//通过反射设置一个字段的值,值为NULL时设置为零值(指针为nil,sql.Null类型的Valid为false)
+func (m *structMapper) setValue(v *reflect.Value, c *columnValue) error {
+	rb := c.bytes
+	if c.null {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	k := v.Kind()
+	switch k {
+	case reflect.Ptr:
+		e := reflect.New(v.Type().Elem()).Elem()
+		err := m.setValue(&e, c)
 		if err != nil {
 			return err
 		}
-		v.Set(reflect.ValueOf(int16(intValue)))
-	case reflect.Int32:
-		intValue, err := strconv.Atoi(string(rb))
+		v.Set(e.Addr())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		intValue, err := strconv.ParseInt(string(rb), 10, v.Type().Bits())
 		if err != nil {
-			return err
+			return fmt.Errorf("Set [%s] value failed:%s", k.String(), err)
 		}
-		v.Set(reflect.ValueOf(int32(intValue)))
-	case reflect.Int64:
-		intValue, err := strconv.Atoi(string(rb))
+		v.SetInt(intValue)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintValue, err := strconv.ParseUint(string(rb), 10, v.Type().Bits())
 		if err != nil {
-			return err
+			return fmt.Errorf("Set [%s] value failed:%s", k.String(), err)
 		}
-		v.Set(reflect.ValueOf(int64(intValue)))
-	case reflect.Float64:
-		floatValue, err := strconv.ParseFloat(string(rb), 64)
+		v.SetUint(uintValue)
+	case reflect.Float32, reflect.Float64:
+		floatValue, err := strconv.ParseFloat(string(rb), v.Type().Bits())
 		if err != nil {
-			return err
+			return fmt.Errorf("Set [%s] value failed:%s", k.String(), err)
+		}
+		v.SetFloat(floatValue)
+	case reflect.Bool:
+		boolValue, err := strconv.ParseBool(string(rb))
+		if err != nil {
+			return fmt.Errorf("Set [%s] value failed:%s", k.String(), err)
 		}
-		v.Set(reflect.ValueOf(floatValue))
+		v.SetBool(boolValue)
 	case reflect.String:
-		v.Set(reflect.ValueOf(string(rb)))
+		v.SetString(string(rb))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("Set [%s] value failed:not support type[%s]", k.String(), v.Type())
+		}
+		v.SetBytes(append([]byte{}, rb...))
 	case reflect.Struct:
-		t, err := time.Parse("2006-01-02 15:04:05", string(rb))
+		if isSqlNull(v.Type()) {
+			f := v.Field(0)
+			err := m.setValue(&f, c)
+			if err != nil {
+				return err
+			}
+			v.Field(1).SetBool(true)
+			return nil
+		}
+		if v.Type() != reflect.TypeOf(time.Time{}) {
+			return fmt.Errorf("Set [%s] value failed:not support type[%s]", k.String(), v.Type())
+		}
+		if t, ok := c.src.(time.Time); ok {
+			if !t.IsZero() {
+				t = t.In(m.timeLocation()) //驱动返回的时间带有时区,直接转换到管理器的时区
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+		t, err := m.parseTime(string(rb))
 		if err != nil {
 			return err
 		}
 		v.Set(reflect.ValueOf(t))
+	default:
+		return fmt.Errorf("Set [%s] value failed:not support type[%s]", k.String(), v.Type())
 	}
 	return nil
 }
 
-func floatToString(f float64) string {
-	return strconv.FormatFloat(f, 'f', 2, 64)
+//转换浮点数为字符串,使用能够准确还原的最少位数,不会截断小数
+func floatToString(f float64, bitSize int) string {
+	return strconv.FormatFloat(f, 'f', -1, bitSize)
 }
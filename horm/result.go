@@ -5,4 +5,6 @@ type Result struct {
 	LastInsertId64 int64
 	RowsAffected   int
 	RowsAffected64 int64
+	Skipped        int //INSERT_IGNORE模式下跳过的记录数
+	Skipped64      int64
 }
@@ -0,0 +1,178 @@
+package horm
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strconv"
+)
+
+//分库分表的结构体实现此接口,表名为GetTableName加上分片的后缀
+type ShardTable interface {
+	Table
+	GetShardRule() *ShardRule
+}
+
+//分片规则
+type ShardRule struct {
+	Key      string        //分片键的列名
+	Strategy ShardStrategy //分片策略
+}
+
+//分片
+type Shard struct {
+	Did    int64  //连接的did,为0时使用当前horm的连接
+	Suffix string //表名后缀
+}
+
+//分片策略
+type ShardStrategy interface {
+	Shard(value interface{}) (*Shard, error) //根据分片键的值计算分片
+	Shards() []*Shard                        //所有的分片,用于没有分片键的查询
+}
+
+//取模分片,Tables张表按顺序平均分布在Dids对应的库中
+type ModStrategy struct {
+	Dids   []int64 //分库的did,为空时不分库
+	Tables int     //分表总数
+	Format string  //表名后缀格式,默认为_%02d
+}
+
+func (m *ModStrategy) Shard(value interface{}) (*Shard, error) {
+	if m.Tables <= 0 {
+		return nil, errors.New("shard tables must be greater than 0")
+	}
+	n, err := shardInt(value)
+	if err != nil {
+		return nil, err
+	}
+	//使用无符号数取绝对值,math.MinInt64取反后仍然是负数
+	u := uint64(n)
+	if n < 0 {
+		u = -u
+	}
+	return m.shard(int(u % uint64(m.Tables))), nil
+}
+
+func (m *ModStrategy) Shards() []*Shard {
+	shards := make([]*Shard, 0, m.Tables)
+	for j := 0; j < m.Tables; j++ {
+		shards = append(shards, m.shard(j))
+	}
+	return shards
+}
+
+//第index张表对应的分片
+func (m *ModStrategy) shard(index int) *Shard {
+	format := m.Format
+	if format == "" {
+		format = "_%02d"
+	}
+	shard := &Shard{Suffix: fmt.Sprintf(format, index)}
+	if len(m.Dids) > 0 {
+		perDb := (m.Tables + len(m.Dids) - 1) / len(m.Dids)
+		shard.Did = m.Dids[index/perDb]
+	}
+	return shard
+}
+
+//哈希分片,对分片键的字符串值做crc32后取模
+type HashStrategy struct {
+	ModStrategy
+}
+
+func (h *HashStrategy) Shard(value interface{}) (*Shard, error) {
+	if h.Tables <= 0 {
+		return nil, errors.New("shard tables must be greater than 0")
+	}
+	v := reflect.Indirect(reflect.ValueOf(value)) //指针按指向的值计算
+	if !v.IsValid() {
+		return nil, errors.New("shard key is nil")
+	}
+	return h.shard(int(crc32.ChecksumIEEE([]byte(fmt.Sprint(v.Interface()))) % uint32(h.Tables))), nil
+}
+
+//范围分片,分片键的值在[Min,Max)之间时使用对应的分片
+type RangeStrategy struct {
+	Ranges []*ShardRange
+}
+
+//分片范围
+type ShardRange struct {
+	Min   int64
+	Max   int64
+	Shard *Shard
+}
+
+func (r *RangeStrategy) Shard(value interface{}) (*Shard, error) {
+	n, err := shardInt(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, sr := range r.Ranges {
+		if n >= sr.Min && n < sr.Max {
+			return sr.Shard, nil
+		}
+	}
+	return nil, fmt.Errorf("no shard for value [%d]", n)
+}
+
+func (r *RangeStrategy) Shards() []*Shard {
+	shards := make([]*Shard, 0, len(r.Ranges))
+	for _, sr := range r.Ranges {
+		shards = append(shards, sr.Shard)
+	}
+	return shards
+}
+
+//把分片键的值转换为整数
+func shardInt(value interface{}) (int64, error) {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.String:
+		return strconv.ParseInt(v.String(), 10, 64)
+	}
+	return 0, fmt.Errorf("shard key [%v] is not an integer", value)
+}
+
+//根据结构体分片键的值计算分片,没有实现ShardTable时返回nil
+func getShard(i interface{}, fieldValueMap map[string]*reflect.Value) (*Shard, error) {
+	table, ok := i.(ShardTable)
+	if !ok {
+		return nil, nil
+	}
+	rule := table.GetShardRule()
+	if rule == nil || rule.Strategy == nil {
+		return nil, errors.New("shard rule can not be empty")
+	}
+	value := fieldValueMap[rule.Key]
+	if value == nil {
+		return nil, fmt.Errorf("shard key [%s] not found", rule.Key)
+	}
+	shard, err := rule.Strategy.Shard(value.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("get shard failed -> %s", err.Error())
+	}
+	return shard, nil
+}
+
+//获取结构体字段值,并根据分片键计算分片,表名加上分片的后缀
+func (m *structMapper) getShardStructValue(i interface{}) (*structValue, error) {
+	sv, err := m.getStructValue(i)
+	if err != nil {
+		return nil, err
+	}
+	sv.shard, err = getShard(i, sv.fieldValueMap)
+	if err != nil {
+		return nil, err
+	}
+	if sv.shard != nil {
+		sv.tableName += sv.shard.Suffix
+	}
+	return sv, nil
+}
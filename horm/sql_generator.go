@@ -2,42 +2,69 @@ package horm
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"errors"
 )
 
 type ISqlGenerator interface {
-	GenerateListSql(i interface{}, conditions ...string) (string, error) //生成查询多条记录sql
-	GenerateFindByIdSql(i interface{}) (string, error)                   //生成根据id查询sql
-	GenerateSaveSql(i interface{}) (string, error)                       //生成保存记录sql
-	GenerateUpdateByIdSql(i interface{}) (string, error)                 //生成根据id更新记录sql
-	GenerateDelByIdSql(i interface{}) (string, error)                    //生成根据Id删除sql
+	GenerateListSql(i interface{}, conditions ...string) (string, error)              //生成查询多条记录sql
+	GenerateFindByIdSql(i interface{}) (string, error)                                //生成根据id查询sql
+	GenerateSaveSql(i interface{}, modes ...InsertMode) (string, error)               //生成保存记录sql
+	GenerateSaveAllSql(list interface{}, modes ...InsertMode) (string, error)         //生成批量保存记录sql
+	GenerateUpdateByIdSql(i interface{}) (string, error)                              //生成根据id更新记录sql
+	GenerateDelByIdSql(i interface{}) (string, error)                                 //生成根据Id删除sql
+	GenerateUpsertSql(i interface{}, conflictColumns ...string) (string, error)       //生成插入或更新记录sql
+	GenerateUpsertAllSql(list interface{}, conflictColumns ...string) (string, error) //生成批量插入或更新记录sql
 }
 
 var sqlGenerator ISqlGenerator = nil
 
-//设置sql生成器
+//设置New创建的全局管理器使用的sql生成器,为nil时使用方言对应的默认生成器
 func SetSqlGenerator(sg ISqlGenerator) {
 	sqlGenerator = sg
 }
 
 type defaultSqlGenerator struct {
+	dialect Dialect
+	mapper  *structMapper
+	logger  ILogger
+}
+
+//创建方言对应的默认sql生成器
+func newDefaultSqlGenerator(dialect Dialect, mapper *structMapper, logger ILogger) ISqlGenerator {
+	return &defaultSqlGenerator{dialect: dialect, mapper: mapper, logger: logger}
 }
 
 func (d *defaultSqlGenerator) GenerateListSql(i interface{}, conditions ...string) (string, error) {
-	structInfo, err := getStuctInfo(i)
+	return d.GenerateShardListSql(i, "", conditions...)
+}
+
+//生成查询多条记录sql,suffix为分片的表名后缀
+func (d *defaultSqlGenerator) GenerateShardListSql(i interface{}, suffix string, conditions ...string) (string, error) {
+	structInfo, err := d.mapper.getStuctInfo(i)
 	if err != nil {
 		return "", fmt.Errorf("get struct reflect type failed -> %s", err.Error())
 	}
-	fields := structInfo.pkColumnName + ","
-	for _, v := range structInfo.structFieldMap {
-		fields += v.Tag.Get(COLUMN_TAG) + ","
+	fields := ""
+	if structInfo.pkColumnName != "" {
+		fields += d.quote(structInfo.pkColumnName) + ","
+	}
+	for column, _ := range structInfo.columnFieldMap {
+		fields += d.quote(column) + ","
 	}
 	fields = strings.TrimSuffix(fields, ",")
 	where := ""
 	sort := ""
+	limit := ""
 	for _, condition := range conditions {
-		if strings.Contains(condition, "=") {
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(condition)), "LIMIT") {
+			limit, err = d.parseLimit(condition)
+			if err != nil {
+				return "", err
+			}
+		} else if strings.Contains(condition, "=") {
 			where += " " + condition
 		} else if strings.Contains(condition, "desc") || strings.Contains(condition, "DESC") || strings.Contains(condition, "asc") || strings.Contains(condition, "ASC") {
 			sort += condition + ","
@@ -50,13 +77,13 @@ func (d *defaultSqlGenerator) GenerateListSql(i interface{}, conditions ...strin
 	if sort != "" {
 		sort = "ORDER BY " + sort
 	}
-	s := fmt.Sprintf("SELECT %s FROM %s %s %s", fields, structInfo.tableName, where, sort)
-	printLog(s)
+	s := fmt.Sprintf("SELECT %s FROM %s %s %s %s", fields, d.quote(structInfo.tableName+suffix), where, sort, limit)
+	d.logger.Printf("%s", s)
 	return s, nil
 }
 
 func (d *defaultSqlGenerator) GenerateFindByIdSql(i interface{}) (string, error) {
-	structValue, err := getStructValue(i)
+	structValue, err := d.structValue(i)
 	if err != nil {
 		return "", fmt.Errorf("get struct reflect value failed -> %s", err.Error())
 	}
@@ -66,47 +93,65 @@ func (d *defaultSqlGenerator) GenerateFindByIdSql(i interface{}) (string, error)
 
 	fields := ""
 	for k, _ := range structValue.fieldStringMap {
-		fields += k + ","
+		fields += d.quote(k) + ","
 	}
 	fields = strings.TrimSuffix(fields, ",")
-	s := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s", fields, structValue.tableName, structValue.pkColumnName, structValue.pkStringValue)
-	printLog(s)
+	s := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s", fields, d.quote(structValue.tableName), d.quote(structValue.pkColumnName), structValue.pkStringValue)
+	d.logger.Printf("%s", s)
 	return s, nil
 }
 
-func (d *defaultSqlGenerator) GenerateSaveSql(i interface{}) (string, error) {
-	structValue, err := getStructValue(i)
+func (d *defaultSqlGenerator) GenerateSaveSql(i interface{}, modes ...InsertMode) (string, error) {
+	sv, err := d.structValue(i)
 	if err != nil {
 		return "", fmt.Errorf("get struct reflect value error -> %s", err.Error())
 	}
-	if structValue.pkColumnName == "" || structValue.pkStringValue == "" {
-		//color.Red("there is no primary key")
+	return d.generateSaveSql([]*structValue{sv}, modes)
+}
+
+func (d *defaultSqlGenerator) GenerateSaveAllSql(list interface{}, modes ...InsertMode) (string, error) {
+	structValues, err := d.structValueList(list)
+	if err != nil {
+		return "", fmt.Errorf("get struct value list error -> %s", err.Error())
 	}
-	if len(structValue.fieldStringMap) == 0 {
-		return "", errors.New("there is no field")
+	return d.generateSaveSql(structValues, modes)
+}
+
+//生成插入sql,插入模式默认为INSERT
+func (d *defaultSqlGenerator) generateSaveSql(structValues []*structValue, modes []InsertMode) (string, error) {
+	mode := INSERT
+	if len(modes) > 0 {
+		mode = modes[0]
+	}
+	columns, values, err := d.insertValues(structValues)
+	if err != nil {
+		return "", err
+	}
+	first := structValues[0]
+	var pkColumns []string
+	if first.pkColumnName != "" {
+		pkColumns = append(pkColumns, d.quote(first.pkColumnName))
 	}
-	fileds := structValue.pkColumnName
-	values := ""
-	if structValue.autoIncrease {
-		values += "DEFAULT"
-	} else {
-		if structValue.pkStringValue != "" {
-			values += structValue.pkStringValue
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if column != first.pkColumnName {
+			updates = append(updates, d.quote(column))
 		}
 	}
-	for k, v := range structValue.fieldStringMap {
-		fileds += "," + k
-		values += "," + v
+	prefix, suffix := d.dialect.InsertMode(mode, pkColumns, updates)
+	if mode == INSERT_REPLACE && len(pkColumns) == 0 && suffix != "" {
+		return "", fmt.Errorf("[%s] has no primary key,can not replace on conflict", first.tableName) //postgres根据主键冲突替换
 	}
-	fileds = strings.TrimPrefix(fileds, ",")
-	values = strings.TrimPrefix(values, ",")
-	s := fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s)", structValue.tableName, fileds, values)
-	printLog(s)
+	s := d.insertSql(prefix, first.tableName, columns, values) + suffix
+	if first.pkColumnName != "" && !d.dialect.SupportLastInsertId() {
+		s += d.dialect.Returning(d.quote(first.pkColumnName))
+	}
+	d.logger.Printf("%s", s)
 	return s, nil
 }
 
 func (d *defaultSqlGenerator) GenerateUpdateByIdSql(i interface{}) (string, error) {
-	structValue, err := getStructValue(i)
+	structValue, err := d.structValue(i)
 	if err != nil {
 		return "", fmt.Errorf("get struct value error:%s", err.Error())
 	}
@@ -118,23 +163,195 @@ func (d *defaultSqlGenerator) GenerateUpdateByIdSql(i interface{}) (string, erro
 	}
 	set := ""
 	for k, v := range structValue.fieldStringMap {
-		set += k + " = " + v + ", "
+		set += d.quote(k) + " = " + v + ", "
 	}
 	set = strings.TrimSuffix(set, ", ")
-	s := "UPDATE " + structValue.tableName + " SET " + set + " WHERE " + structValue.pkColumnName + " = " + structValue.pkStringValue
-	printLog(s)
+	s := "UPDATE " + d.quote(structValue.tableName) + " SET " + set + " WHERE " + d.quote(structValue.pkColumnName) + " = " + structValue.pkStringValue
+	d.logger.Printf("%s", s)
 	return s, nil
 }
 
 func (d *defaultSqlGenerator) GenerateDelByIdSql(i interface{}) (string, error) {
-	structValue, err := getStructValue(i)
+	structValue, err := d.structValue(i)
 	if err != nil {
 		return "", fmt.Errorf("get struct value error -> %s", err.Error())
 	}
 	if structValue.pkColumnName == "" || structValue.pkStringValue == "" {
 		return "", errors.New("primary key can not be empty")
 	}
-	s := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", structValue.tableName, structValue.pkColumnName, structValue.pkStringValue)
-	printLog(s)
+	s := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", d.quote(structValue.tableName), d.quote(structValue.pkColumnName), structValue.pkStringValue)
+	d.logger.Printf("%s", s)
+	return s, nil
+}
+
+func (d *defaultSqlGenerator) GenerateUpsertSql(i interface{}, conflictColumns ...string) (string, error) {
+	sv, err := d.structValue(i)
+	if err != nil {
+		return "", fmt.Errorf("get struct value error -> %s", err.Error())
+	}
+	return d.generateUpsertSql([]*structValue{sv}, conflictColumns)
+}
+
+func (d *defaultSqlGenerator) GenerateUpsertAllSql(list interface{}, conflictColumns ...string) (string, error) {
+	structValues, err := d.structValueList(list)
+	if err != nil {
+		return "", fmt.Errorf("get struct value list error -> %s", err.Error())
+	}
+	return d.generateUpsertSql(structValues, conflictColumns)
+}
+
+//生成插入或更新sql,冲突列默认为主键,除主键和冲突列以外的列都会被更新
+func (d *defaultSqlGenerator) generateUpsertSql(structValues []*structValue, conflictColumns []string) (string, error) {
+	columns, values, err := d.insertValues(structValues)
+	if err != nil {
+		return "", err
+	}
+	first := structValues[0]
+	if len(conflictColumns) == 0 {
+		if first.pkColumnName == "" {
+			return "", errors.New("conflict columns can not be empty")
+		}
+		conflictColumns = []string{first.pkColumnName}
+	}
+	conflicts := make([]string, 0, len(conflictColumns))
+	for _, column := range conflictColumns {
+		conflicts = append(conflicts, d.quote(column))
+	}
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if column != first.pkColumnName && !containsString(conflictColumns, column) {
+			updates = append(updates, d.quote(column))
+		}
+	}
+	s := d.insertSql("INSERT INTO", first.tableName, columns, values) + d.dialect.Upsert(conflicts, updates)
+	d.logger.Printf("%s", s)
 	return s, nil
 }
+
+//生成批量插入的列名和每条记录的值,自增主键为零值时使用方言的自增值
+func (d *defaultSqlGenerator) insertValues(structValues []*structValue) ([]string, []string, error) {
+	if len(structValues) == 0 {
+		return nil, nil, errors.New("there is no record")
+	}
+	first := structValues[0]
+	if len(first.fieldStringMap) == 0 {
+		return nil, nil, errors.New("there is no field")
+	}
+	columns := make([]string, 0, len(first.fieldStringMap)+1)
+	for column, _ := range first.fieldStringMap {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	if first.pkColumnName != "" {
+		columns = append([]string{first.pkColumnName}, columns...)
+	}
+	values := make([]string, 0, len(structValues))
+	for _, structValue := range structValues {
+		if structValue.tableName != first.tableName {
+			return nil, nil, fmt.Errorf("table [%s] and [%s] can not be inserted together", first.tableName, structValue.tableName)
+		}
+		value := make([]string, 0, len(columns))
+		for _, column := range columns {
+			if column != structValue.pkColumnName {
+				value = append(value, structValue.fieldStringMap[column])
+			} else if structValue.autoIncrease && structValue.fieldValueMap[column].IsZero() {
+				value = append(value, d.dialect.AutoIncrementValue())
+			} else {
+				value = append(value, structValue.pkStringValue)
+			}
+		}
+		values = append(values, "("+strings.Join(value, ",")+")")
+	}
+	return columns, values, nil
+}
+
+//拼接插入sql,insertInto为INSERT INTO或者方言插入模式对应的前缀
+func (d *defaultSqlGenerator) insertSql(insertInto string, tableName string, columns []string, values []string) string {
+	quoted := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quoted = append(quoted, d.quote(column))
+	}
+	return fmt.Sprintf("%s %s(%s) VALUES%s", insertInto, d.quote(tableName), strings.Join(quoted, ","), strings.Join(values, ","))
+}
+
+//获取结构体字段值,字段值转换为方言的字面量
+func (d *defaultSqlGenerator) structValue(i interface{}) (*structValue, error) {
+	sv, err := d.mapper.getShardStructValue(i)
+	if err != nil {
+		return nil, err
+	}
+	d.literal(sv)
+	return sv, nil
+}
+
+//获取结构体切片中每个元素的字段值,字段值转换为方言的字面量
+func (d *defaultSqlGenerator) structValueList(list interface{}) ([]*structValue, error) {
+	structValues, err := d.mapper.getStructValueList(list)
+	if err != nil {
+		return nil, err
+	}
+	for _, sv := range structValues {
+		d.literal(sv)
+	}
+	return structValues, nil
+}
+
+//把字段值和主键值转换为方言的字面量
+func (d *defaultSqlGenerator) literal(sv *structValue) {
+	for column, value := range sv.fieldStringMap {
+		sv.fieldStringMap[column] = d.dialect.Literal(value)
+	}
+	sv.pkStringValue = d.dialect.Literal(sv.pkStringValue)
+}
+
+//按方言引用表名或列名
+func (d *defaultSqlGenerator) quote(name string) string {
+	return quoteIdentifier(d.dialect, name)
+}
+
+//解析分页条件,支持"LIMIT 10","LIMIT 10 OFFSET 20"和"LIMIT 20,10"
+func (d *defaultSqlGenerator) parseLimit(condition string) (string, error) {
+	fields := strings.Fields(strings.Replace(strings.TrimSpace(condition)[len("LIMIT"):], ",", " , ", 1))
+	limit, offset := 0, 0
+	var err error
+	switch {
+	case len(fields) == 1:
+		limit, err = strconv.Atoi(fields[0])
+	case len(fields) == 3 && fields[1] == ",":
+		offset, err = strconv.Atoi(fields[0])
+		if err == nil {
+			limit, err = strconv.Atoi(fields[2])
+		}
+	case len(fields) == 3 && strings.ToUpper(fields[1]) == "OFFSET":
+		limit, err = strconv.Atoi(fields[0])
+		if err == nil {
+			offset, err = strconv.Atoi(fields[2])
+		}
+	default:
+		err = errors.New("unknown format")
+	}
+	if err != nil {
+		return "", fmt.Errorf("parse condition [%s] failed -> %s", condition, err.Error())
+	}
+	return d.dialect.Limit(limit, offset), nil
+}
+
+//支持分库分表的sql生成器,实现了ShardTable的结构体只能使用实现了此接口的生成器,
+//生成器需要在所有sql的表名后加上分片的后缀(可以参考默认生成器的实现)
+type IShardSqlGenerator interface {
+	ISqlGenerator
+	GenerateShardListSql(i interface{}, suffix string, conditions ...string) (string, error) //生成查询一个分片多条记录的sql,suffix为分片的表名后缀
+}
+
+//条件中是否有排序或者分页,和GenerateListSql对条件的判断一致
+func hasOrderOrLimit(conditions []string) bool {
+	for _, condition := range conditions {
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(condition)), "LIMIT") {
+			return true
+		}
+		if !strings.Contains(condition, "=") && (strings.Contains(strings.ToLower(condition), "desc") || strings.Contains(strings.ToLower(condition), "asc")) {
+			return true
+		}
+	}
+	return false
+}
@@ -10,15 +10,19 @@ type tableStruct struct {
 	Type  string `field:"Type"`
 }
 
+func (t *tableStruct) GetTableName() string {
+	return ""
+}
+
 func GenerateStruct(h IHorm, tableName string, structName string) (string, error) {
 	ts := new([]tableStruct)
-	err := h.Query("DESC "+tableName, ts)
+	err := h.Query(h.Dialect().DescribeTableSql(tableName), ts)
 	if err != nil {
 		return "", fmt.Errorf("Generate table struct failed:%s", err.Error())
 	}
 	structStr := fmt.Sprintf("\ntype %s struct {\n", structName)
 	for _, v := range *ts {
-		structStr += fmt.Sprintf("\t%-20s %-20s `field:\"%s\"`\n", getCamelString(v.Field), getStructType(v.Type), v.Field)
+		structStr += fmt.Sprintf("\t%-20s %-20s `field:\"%s%s\"`\n", getCamelString(v.Field), getStructType(v.Type), v.Field, getScaleOption(v.Type))
 	}
 	structStr += "}\n"
 	structStr += fmt.Sprintf("func (%s *%s)GetTableName() string {\n\treturn \"%s\"\n}", ([]byte(structName))[0:1], structName, tableName)
@@ -28,16 +32,34 @@ func GenerateStruct(h IHorm, tableName string, structName string) (string, error
 func getStructType(dbType string) string {
 	if strings.Contains(dbType, "int") {
 		return "int"
-	} else if strings.Contains(dbType, "varchar") {
+	} else if strings.Contains(dbType, "char") || strings.Contains(dbType, "text") {
 		return "string"
 	} else if strings.Contains(dbType, "timestamp") || strings.Contains(dbType, "datetime") {
 		return "time.Time"
-	} else if strings.Contains(dbType, "decimal") || strings.Contains(dbType, "datetime") {
+	} else if strings.Contains(dbType, "decimal") || strings.Contains(dbType, "numeric") {
+		return "horm.Decimal"
+	} else if strings.Contains(dbType, "double") {
 		return "float64"
+	} else if strings.Contains(dbType, "blob") || strings.Contains(dbType, "binary") || strings.Contains(dbType, "bytea") {
+		return "[]byte"
+	} else if strings.Contains(dbType, "bool") {
+		return "bool"
 	}
 	return "unknown"
 }
 
+//decimal(18,4)等声明了小数位数的类型对应的scale选项
+func getScaleOption(dbType string) string {
+	if !strings.Contains(dbType, "decimal") && !strings.Contains(dbType, "numeric") {
+		return ""
+	}
+	start, end := strings.Index(dbType, ","), strings.Index(dbType, ")")
+	if start < 0 || end < start {
+		return ""
+	}
+	return ",scale=" + strings.TrimSpace(dbType[start+1:end])
+}
+
 func getCamelString(unCamelString string) string {
 	s := strings.Split(unCamelString, "_")
 	camelString := ""
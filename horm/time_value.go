@@ -0,0 +1,79 @@
+package horm
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	DATE_LAYOUT     string = "2006-01-02"                    //date列的格式
+	CLOCK_LAYOUT    string = "15:04:05"                      //time列的格式
+	DATETIME_LAYOUT string = "2006-01-02 15:04:05"           //datetime列的格式
+	TIME_LAYOUT     string = "2006-01-02 15:04:05.999999999" //读取时间的格式,小数秒可以省略
+)
+
+//读取时间时依次尝试的格式,带有时区的时间转换到管理器的时区
+var parseTimeLayouts = []string{
+	TIME_LAYOUT,
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	DATE_LAYOUT,
+	CLOCK_LAYOUT + ".999999999",
+}
+
+//读写时间使用的时区
+func (m *structMapper) timeLocation() *time.Location {
+	if m.location == nil {
+		return time.Local
+	}
+	return m.location
+}
+
+//把时间转换为sql字面量,转换到管理器的时区后按列的类型和精度格式化,零值时间在设置了zeroTimeNull时转换为NULL
+func (m *structMapper) formatTime(t time.Time, option *columnOption) string {
+	if t.IsZero() && m.zeroTimeNull {
+		return "NULL"
+	}
+	if !t.IsZero() {
+		t = t.In(m.timeLocation()) //零值时间不转换时区,读取时还原为零值
+	}
+	precision := m.timePrecision
+	layout := DATETIME_LAYOUT
+	if option != nil {
+		if option.precision >= 0 {
+			precision = option.precision
+		}
+		switch option.timeType {
+		case "date":
+			layout, precision = DATE_LAYOUT, 0
+		case "time":
+			layout = CLOCK_LAYOUT
+		}
+	}
+	if precision > 9 {
+		precision = 9
+	}
+	if precision > 0 {
+		layout += "." + strings.Repeat("0", precision) //小数秒截断而不是四舍五入
+	}
+	return "'" + t.Format(layout) + "'"
+}
+
+//解析数据库返回的时间,支持datetime(带有或不带小数秒),date和time,没有时区的时间使用管理器的时区
+func (m *structMapper) parseTime(s string) (time.Time, error) {
+	if strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, nil //mysql的零值日期
+	}
+	var err error
+	for _, layout := range parseTimeLayouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, s, m.timeLocation())
+		if err == nil {
+			if t.Equal(time.Date(1, 1, 1, 0, 0, 0, 0, t.Location())) {
+				return time.Time{}, nil //零值时间写入时没有转换时区
+			}
+			return t.In(m.timeLocation()), nil
+		}
+	}
+	return time.Time{}, err
+}
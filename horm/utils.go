@@ -2,8 +2,6 @@ package horm
 
 import (
 	"bytes"
-	"github.com/fatih/color"
-	"log"
 	"runtime"
 	"strconv"
 )
@@ -18,9 +16,12 @@ func getGID() uint64 {
 	return n
 }
 
-func printLog(s string) {
-	if isPrintLog {
-		formatS := color.GreenString("%s", s)
-		log.Printf("[horm]:%s", formatS)
+//切片中是否包含字符串
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
 	}
+	return false
 }